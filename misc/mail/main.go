@@ -53,29 +53,18 @@ func hideCode(code string) string {
 }
 
 func main() {
-	// 推荐：从环境变量读取凭据（更安全）
-	// 如果你想直接硬编码（仅用于临时测试），将下面的 os.Getenv 替换为字符串常量即可。
-	smtpHost := os.Getenv("SMTP_HOST")   // e.g. "smtp.163.com"
-	smtpPortStr := os.Getenv("SMTP_PORT")// e.g. "465"
-	smtpUser := os.Getenv("SMTP_USER")   // e.g. "travellingdotgo@163.com"
-	smtpPass := os.Getenv("SMTP_PASS")   // e.g. "QPxuBZT9FQyKNiH3"
-	to := os.Getenv("TO_EMAIL")          // e.g. "atu.cool@qq.com"
-
-	// 如果环境变量未设置，为了方便测试，这里给出示例默认值（请在真实环境删除或替换）
-	if smtpHost == "" {
-		smtpHost = "smtp.163.com"
-	}
+	// 凭据只从环境变量读取，不在代码中保存
+	smtpHost := os.Getenv("SMTP_HOST")    // e.g. "smtp.163.com"
+	smtpPortStr := os.Getenv("SMTP_PORT") // e.g. "465"
+	smtpUser := os.Getenv("SMTP_USER")
+	smtpPass := os.Getenv("SMTP_PASS")
+	to := os.Getenv("TO_EMAIL")
+
 	if smtpPortStr == "" {
 		smtpPortStr = "465"
 	}
-	if smtpUser == "" {
-		smtpUser = "travellingdotgo@163.com" // 你提供的账号
-	}
-	if smtpPass == "" {
-		smtpPass = "QPxuBZT9FQyKNiH3" // 你提供的临时密钥（仅示例）
-	}
-	if to == "" {
-		to = "atu.cool@qq.com"
+	if smtpHost == "" || smtpUser == "" || smtpPass == "" || to == "" {
+		log.Fatal("请设置 SMTP_HOST、SMTP_USER、SMTP_PASS 与 TO_EMAIL 环境变量")
 	}
 
 	// 把端口字符串转为整数
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 告警：规则按周期对设备遥测与在线状态求值，条件成立时产生 firing 告警，
+// 条件消失后转为 resolved；静默按规则与设备生效，期内告警照常触发与恢复，但不发布事件
+const (
+	alertThreshold = "threshold" // 指标持续 duration 秒超过阈值
+	alertOffline   = "offline"   // 设备离线超过 duration 秒
+	alertRate      = "rate"      // window 秒内指标每分钟变化率超过阈值
+
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+
+	// 指标最新数据点超过该时长视为无数据，不再判定为超限
+	alertStaleAfter = 5 * time.Minute
+
+	defaultOfflineDuration = 5 * 60
+	defaultRateWindow      = 5 * 60
+	maxSilenceDuration     = 7 * 24 * 3600
+)
+
+type AlertRule struct {
+	ID          string  `json:"id"`
+	WorkspaceID string  `json:"workspaceId"`
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"` // threshold | offline | rate
+	Severity    string  `json:"severity"`
+	DeviceType  string  `json:"deviceType,omitempty"` // 为空表示不限类型
+	Selector    string  `json:"selector,omitempty"`   // 标签选择器，为空表示工作区内全部设备
+	Metric      string  `json:"metric,omitempty"`
+	Op          string  `json:"op,omitempty"` // > | >= | < | <=
+	Threshold   float64 `json:"threshold"`
+	Duration    int64   `json:"duration"`         // 秒
+	Window      int64   `json:"window,omitempty"` // 秒，仅 rate
+	Enabled     bool    `json:"enabled"`
+	OwnerID     string  `json:"ownerId"`
+	CreatedAt   int64   `json:"createdAt"`
+	UpdatedAt   int64   `json:"updatedAt"`
+}
+
+// 同一规则与设备同时最多一个 firing 告警
+type Alert struct {
+	ID            string  `json:"id"`
+	WorkspaceID   string  `json:"workspaceId"`
+	RuleID        string  `json:"ruleId"`
+	RuleName      string  `json:"ruleName"`
+	Kind          string  `json:"kind"`
+	Severity      string  `json:"severity"`
+	DeviceID      string  `json:"deviceId"`
+	Status        string  `json:"status"` // firing | resolved
+	Value         float64 `json:"value"`  // 触发时的指标值、变化率或离线秒数
+	Message       string  `json:"message"`
+	StartsAt      int64   `json:"startsAt"`
+	ResolvedAt    int64   `json:"resolvedAt,omitempty"`
+	SilencedUntil int64   `json:"silencedUntil,omitempty"` // 由 alertResponse 按所属规则与设备的静默填充
+	SilencedBy    string  `json:"silencedBy,omitempty"`
+	UpdatedAt     int64   `json:"updatedAt"`
+}
+
+// 对某条规则在某台设备上的告警静默，到期前该规则与设备的告警都不发布事件
+type AlertSilence struct {
+	RuleID      string `json:"ruleId"`
+	DeviceID    string `json:"deviceId"`
+	WorkspaceID string `json:"workspaceId"`
+	Until       int64  `json:"until"`
+	CreatedBy   string `json:"createdBy"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+type AlertResponse struct {
+	Alert
+	Silenced bool `json:"silenced"`
+}
+
+// PUT 时同样使用该结构整体替换；enabled 省略时为 true
+type AlertRuleRequest struct {
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"`
+	Severity   string   `json:"severity"`
+	DeviceType string   `json:"deviceType"`
+	Labels     string   `json:"labels"`
+	Metric     string   `json:"metric"`
+	Op         string   `json:"op"`
+	Threshold  *float64 `json:"threshold"`
+	Duration   int64    `json:"duration"`
+	Window     int64    `json:"window"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+type SilenceAlertRequest struct {
+	Duration int64 `json:"duration"` // 秒
+}
+
+func alertResponse(a Alert, now time.Time) AlertResponse {
+	if sl, ok := alertSilence(a.RuleID, a.DeviceID, now); ok {
+		a.SilencedUntil = sl.Until
+		a.SilencedBy = sl.CreatedBy
+	}
+	return AlertResponse{Alert: a, Silenced: a.SilencedUntil > now.Unix()}
+}
+
+// 规则与设备当前生效的静默
+func alertSilence(ruleID, deviceID string, now time.Time) (AlertSilence, bool) {
+	sl, ok := store.GetAlertSilence(ruleID, deviceID)
+	if !ok || sl.Until <= now.Unix() {
+		return AlertSilence{}, false
+	}
+	return sl, true
+}
+
+func compareOp(op string, v, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	}
+	return false
+}
+
+// 校验请求并填充规则的可编辑字段
+func (req AlertRuleRequest) apply(rule *AlertRule) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return errors.New("Rule name is required")
+	}
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if kind != alertThreshold && kind != alertOffline && kind != alertRate {
+		return errors.New("Kind must be threshold, offline or rate")
+	}
+	severity := strings.ToLower(strings.TrimSpace(req.Severity))
+	if severity == "" {
+		severity = severityWarning
+	}
+	if severity != severityInfo && severity != severityWarning && severity != severityCritical {
+		return errors.New("Severity must be info, warning or critical")
+	}
+	sel, err := parseLabelSelector(req.Labels)
+	if err != nil {
+		return err
+	}
+	if req.Duration < 0 || req.Window < 0 {
+		return errors.New("duration and window must not be negative")
+	}
+
+	r := AlertRule{Name: name, Kind: kind, Severity: severity, DeviceType: strings.TrimSpace(req.DeviceType), Selector: sel.String()}
+	switch kind {
+	case alertOffline:
+		r.Duration = req.Duration
+		if r.Duration == 0 {
+			r.Duration = defaultOfflineDuration
+		}
+	default:
+		r.Metric = strings.TrimSpace(req.Metric)
+		r.Op = strings.TrimSpace(req.Op)
+		if r.Metric == "" {
+			return errors.New("Metric is required")
+		}
+		if r.Op != ">" && r.Op != ">=" && r.Op != "<" && r.Op != "<=" {
+			return errors.New("Op must be one of >, >=, <, <=")
+		}
+		if req.Threshold == nil {
+			return errors.New("Threshold is required")
+		}
+		r.Threshold = *req.Threshold
+		if kind == alertThreshold {
+			r.Duration = req.Duration
+		} else {
+			r.Window = req.Window
+			if r.Window == 0 {
+				r.Window = defaultRateWindow
+			}
+			if r.Window < 60 {
+				return errors.New("window must be at least 60 seconds")
+			}
+		}
+	}
+	if time.Duration(r.Duration+r.Window)*time.Second > cfg.TelemetryRetention {
+		return errors.New("duration and window must be within the telemetry retention period")
+	}
+
+	rule.Name, rule.Kind, rule.Severity = r.Name, r.Kind, r.Severity
+	rule.DeviceType, rule.Selector = r.DeviceType, r.Selector
+	rule.Metric, rule.Op, rule.Threshold = r.Metric, r.Op, r.Threshold
+	rule.Duration, rule.Window = r.Duration, r.Window
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+func (r AlertRule) targets(d Device) bool {
+	if d.WorkspaceID != r.WorkspaceID {
+		return false
+	}
+	if r.DeviceType != "" && !strings.EqualFold(d.Type, r.DeviceType) {
+		return false
+	}
+	sel, _ := parseLabelSelector(r.Selector)
+	return sel.matches(d.Labels)
+}
+
+// 对单个设备求值，返回是否触发、触发值与说明
+func (r AlertRule) evaluate(d Device, now time.Time) (bool, float64, string) {
+	switch r.Kind {
+	case alertOffline:
+		offline := now.Unix() - d.LastOnline
+		if deviceOnline(d, now) || offline < r.Duration {
+			return false, 0, ""
+		}
+		return true, float64(offline), fmt.Sprintf("%s offline for %ds", d.Name, offline)
+
+	case alertThreshold:
+		// 最新点超限，且连续超限的起点距今至少 duration 秒
+		from := now.Add(-time.Duration(r.Duration)*time.Second - alertStaleAfter).Unix()
+		pts := telemetry.Range(d.ID, r.Metric, from, now.Unix())
+		if len(pts) == 0 {
+			return false, 0, ""
+		}
+		last := pts[len(pts)-1]
+		if now.Unix()-last.TS > int64(alertStaleAfter/time.Second) || !compareOp(r.Op, last.Value, r.Threshold) {
+			return false, 0, ""
+		}
+		start := last.TS
+		for i := len(pts) - 1; i >= 0 && compareOp(r.Op, pts[i].Value, r.Threshold); i-- {
+			start = pts[i].TS
+		}
+		if now.Unix()-start < r.Duration {
+			return false, 0, ""
+		}
+		return true, last.Value, fmt.Sprintf("%s %s %g (%s %g)", d.Name, r.Metric, last.Value, r.Op, r.Threshold)
+
+	case alertRate:
+		pts := telemetry.Range(d.ID, r.Metric, now.Unix()-r.Window, now.Unix())
+		if len(pts) < 2 {
+			return false, 0, ""
+		}
+		first, last := pts[0], pts[len(pts)-1]
+		if now.Unix()-last.TS > int64(alertStaleAfter/time.Second) || last.TS == first.TS {
+			return false, 0, ""
+		}
+		rate := (last.Value - first.Value) / float64(last.TS-first.TS) * 60
+		if !compareOp(r.Op, rate, r.Threshold) {
+			return false, 0, ""
+		}
+		return true, rate, fmt.Sprintf("%s %s changing at %g/min (%s %g)", d.Name, r.Metric, rate, r.Op, r.Threshold)
+	}
+	return false, 0, ""
+}
+
+// 后台求值：周期性评估全部启用的规则
+func alertEvaluator(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		evaluateAlerts(time.Now())
+	}
+}
+
+func evaluateAlerts(now time.Time) {
+	// 当前 firing 的告警，键为 规则ID/设备ID
+	open := map[string]Alert{}
+	for _, a := range store.ListAlerts() {
+		switch {
+		case a.Status == alertFiring:
+			open[a.RuleID+"/"+a.DeviceID] = a
+		case now.Unix()-a.ResolvedAt > int64(cfg.AlertRetention/time.Second):
+			store.DeleteAlert(a.ID)
+		}
+	}
+	for _, sl := range store.ListAlertSilences() {
+		if sl.Until <= now.Unix() {
+			store.DeleteAlertSilence(sl.RuleID, sl.DeviceID)
+		}
+	}
+
+	devices := store.ListDevices()
+	for _, r := range store.ListAlertRules() {
+		if !r.Enabled {
+			continue
+		}
+		for _, d := range devices {
+			if !r.targets(d) {
+				continue
+			}
+			key := r.ID + "/" + d.ID
+			fired, value, msg := r.evaluate(d, now)
+			a, isOpen := open[key]
+			delete(open, key)
+			switch {
+			case fired && !isOpen:
+				fireAlert(r, d, value, msg, now)
+			case !fired && isOpen:
+				resolveAlert(a, now)
+			}
+		}
+	}
+	// 规则已删除或停用、设备已删除或不再匹配
+	for _, a := range open {
+		resolveAlert(a, now)
+	}
+}
+
+func fireAlert(r AlertRule, d Device, value float64, msg string, now time.Time) {
+	a := Alert{
+		ID:          fmt.Sprintf("al-%d", store.NextSeq("alert")),
+		WorkspaceID: r.WorkspaceID,
+		RuleID:      r.ID,
+		RuleName:    r.Name,
+		Kind:        r.Kind,
+		Severity:    r.Severity,
+		DeviceID:    d.ID,
+		Status:      alertFiring,
+		Value:       value,
+		Message:     msg,
+		StartsAt:    now.Unix(),
+		UpdatedAt:   now.Unix(),
+	}
+	if err := store.CreateAlert(a); err != nil {
+		log.Printf("alerts: create %s: %v", a.ID, err)
+		return
+	}
+	if _, silenced := alertSilence(a.RuleID, a.DeviceID, now); !silenced {
+		publishAlert("alert.firing", a)
+	}
+}
+
+func resolveAlert(a Alert, now time.Time) {
+	a, err := store.UpdateAlert(a.ID, func(a *Alert) error {
+		if a.Status != alertFiring {
+			return errConflict
+		}
+		a.Status = alertResolved
+		a.ResolvedAt = now.Unix()
+		a.UpdatedAt = now.Unix()
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if _, silenced := alertSilence(a.RuleID, a.DeviceID, now); !silenced {
+		publishAlert("alert.resolved", a)
+	}
+}
+
+func publishAlert(typ string, a Alert) {
+	publishEvent(Event{
+		Type:        typ,
+		DeviceID:    a.DeviceID,
+		WorkspaceID: a.WorkspaceID,
+		Data: map[string]interface{}{
+			"alertId":  a.ID,
+			"ruleId":   a.RuleID,
+			"severity": a.Severity,
+			"value":    a.Value,
+			"message":  a.Message,
+		},
+	})
+}
+
+// GET /api/v1/alert-rules (list), POST /api/v1/alert-rules (create)
+func alertRulesCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listAlertRules(w, r)
+	case http.MethodPost:
+		createAlertRule(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET/PUT/DELETE /api/v1/alert-rules/{id}
+func alertRuleResourceHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/alert-rules/")
+	if id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	rule, ok := store.GetAlertRule(id)
+	if !ok || rule.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Alert rule not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		updateAlertRule(w, r, rule)
+	case http.MethodDelete:
+		if !canModify(p, rule.OwnerID) {
+			writeForbidden(w)
+			return
+		}
+		if !store.DeleteAlertRule(id) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "Alert rule not found"})
+			return
+		}
+		// 该规则的 firing 告警在下一轮求值时转为 resolved
+		writeJSON(w, http.StatusNoContent, nil)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func listAlertRules(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	list := make([]AlertRule, 0)
+	for _, rule := range store.ListAlertRules() {
+		if rule.WorkspaceID == p.WorkspaceID {
+			list = append(list, rule)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": list, "total": len(list)})
+}
+
+func readAlertRuleRequest(w http.ResponseWriter, r *http.Request) (AlertRuleRequest, bool) {
+	var req AlertRuleRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return req, false
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return req, false
+	}
+	return req, true
+}
+
+func createAlertRule(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+	req, ok := readAlertRuleRequest(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	rule := AlertRule{WorkspaceID: p.WorkspaceID, OwnerID: p.UserID, CreatedAt: now, UpdatedAt: now}
+	if err := req.apply(&rule); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	rule.ID = fmt.Sprintf("ar-%d", store.NextSeq("alertrule"))
+	if err := store.CreateAlertRule(rule); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Alert rule already exists"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func updateAlertRule(w http.ResponseWriter, r *http.Request, rule AlertRule) {
+	p, _ := currentPrincipal(r)
+	if !canModify(p, rule.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	req, ok := readAlertRuleRequest(w, r)
+	if !ok {
+		return
+	}
+	// 先在副本上校验，闭包内只需写入
+	if err := req.apply(&rule); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	rule, err := store.UpdateAlertRule(rule.ID, func(cur *AlertRule) error {
+		_ = req.apply(cur)
+		cur.UpdatedAt = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Alert rule not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// GET /api/v1/alerts?status=&severity=&rule_id=&device_id= (list)
+func alertsCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	q := r.URL.Query()
+	status := strings.ToLower(strings.TrimSpace(q.Get("status")))
+	if status != "" && status != alertFiring && status != alertResolved {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be firing or resolved"})
+		return
+	}
+	severity := strings.ToLower(strings.TrimSpace(q.Get("severity")))
+	ruleID := strings.TrimSpace(q.Get("rule_id"))
+	deviceID := strings.TrimSpace(q.Get("device_id"))
+
+	now := time.Now()
+	list := make([]AlertResponse, 0)
+	for _, a := range store.ListAlerts() {
+		if a.WorkspaceID != p.WorkspaceID ||
+			status != "" && a.Status != status ||
+			severity != "" && a.Severity != severity ||
+			ruleID != "" && a.RuleID != ruleID ||
+			deviceID != "" && a.DeviceID != deviceID {
+			continue
+		}
+		list = append(list, alertResponse(a, now))
+	}
+	// firing 在前，其余按开始时间倒序
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Status == alertFiring) != (list[j].Status == alertFiring) {
+			return list[i].Status == alertFiring
+		}
+		return list[i].StartsAt > list[j].StartsAt
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"alerts": list, "total": len(list)})
+}
+
+// GET /api/v1/alerts/{id}, POST/DELETE /api/v1/alerts/{id}/silence
+func alertResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/alerts/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || len(parts) == 2 && parts[1] != "silence" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	a, ok := store.GetAlert(id)
+	if !ok || a.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Alert not found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, alertResponse(a, time.Now()))
+	case len(parts) == 2 && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		silenceAlert(w, r, p, a)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// POST 静默该告警所属规则与设备 duration 秒，DELETE 取消静默
+func silenceAlert(w http.ResponseWriter, r *http.Request, p Principal, a Alert) {
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+	now := time.Now()
+	until := int64(0)
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+		var req SilenceAlertRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+			return
+		}
+		if req.Duration <= 0 || req.Duration > maxSilenceDuration {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("duration must be between 1 and %d seconds", maxSilenceDuration)})
+			return
+		}
+		if a.Status != alertFiring {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Alert is already resolved"})
+			return
+		}
+		until = now.Unix() + req.Duration
+	}
+
+	if until > 0 {
+		store.PutAlertSilence(AlertSilence{
+			RuleID:      a.RuleID,
+			DeviceID:    a.DeviceID,
+			WorkspaceID: a.WorkspaceID,
+			Until:       until,
+			CreatedBy:   p.UserID,
+			CreatedAt:   now.Unix(),
+		})
+	} else {
+		store.DeleteAlertSilence(a.RuleID, a.DeviceID)
+	}
+	writeJSON(w, http.StatusOK, alertResponse(a, now))
+}
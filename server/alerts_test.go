@@ -0,0 +1,222 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 替换遥测与存储为空的内存实现，测试结束后恢复
+func stubAlertStores(t *testing.T) {
+	origTelemetry, origStore := telemetry, store
+	telemetry = &tsStore{series: map[string]map[string][]tsPoint{}}
+	store = newMemoryStore()
+	t.Cleanup(func() { telemetry, store = origTelemetry, origStore })
+}
+
+// 写入 metric 指标，offsets 为相对 now 的秒数
+func appendPoints(t *testing.T, deviceID, metric string, now time.Time, offsets []int64, values []float64) {
+	var pts []TelemetryPoint
+	for i, off := range offsets {
+		pts = append(pts, TelemetryPoint{Metric: metric, TS: now.Unix() + off, Value: values[i]})
+	}
+	if err := telemetry.Append(deviceID, pts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlertRuleEvaluateThreshold(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := Device{ID: "dev-1", Name: "sensor"}
+
+	tests := []struct {
+		name      string
+		offsets   []int64
+		values    []float64
+		duration  int64
+		wantFired bool
+		wantValue float64
+	}{
+		{name: "no data", duration: 60},
+		{name: "sustained for duration", duration: 60, offsets: []int64{-120, -60, 0}, values: []float64{60, 70, 80}, wantFired: true, wantValue: 80},
+		{name: "breach too short", duration: 60, offsets: []int64{-120, -30, 0}, values: []float64{40, 60, 70}},
+		{name: "run starts after last dip", duration: 60, offsets: []int64{-120, -90, -60, 0}, values: []float64{60, 40, 60, 60}, wantFired: true, wantValue: 60},
+		{name: "dip inside duration", duration: 60, offsets: []int64{-120, -50, 0}, values: []float64{60, 40, 60}},
+		{name: "latest below threshold", duration: 60, offsets: []int64{-120, -60, 0}, values: []float64{60, 70, 50}},
+		{name: "latest point stale", duration: 60, offsets: []int64{-400, -301}, values: []float64{60, 60}},
+		{name: "latest point at stale cutoff", duration: 60, offsets: []int64{-400, -300}, values: []float64{60, 60}, wantFired: true, wantValue: 60},
+		{name: "zero duration fires on single point", offsets: []int64{0}, values: []float64{51}, wantFired: true, wantValue: 51},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAlertStores(t)
+			appendPoints(t, d.ID, "temp", now, tt.offsets, tt.values)
+			r := AlertRule{Kind: alertThreshold, Metric: "temp", Op: ">", Threshold: 50, Duration: tt.duration}
+			fired, value, _ := r.evaluate(d, now)
+			if fired != tt.wantFired || value != tt.wantValue {
+				t.Errorf("evaluate = (%v, %g), want (%v, %g)", fired, value, tt.wantFired, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestAlertRuleEvaluateRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := Device{ID: "dev-1", Name: "sensor"}
+
+	tests := []struct {
+		name      string
+		op        string
+		threshold float64
+		window    int64
+		offsets   []int64
+		values    []float64
+		wantFired bool
+		wantValue float64
+	}{
+		{name: "single point", op: ">", threshold: 10, window: 300, offsets: []int64{0}, values: []float64{100}},
+		{name: "rising fast", op: ">", threshold: 10, window: 300, offsets: []int64{-120, -60, 0}, values: []float64{0, 50, 60}, wantFired: true, wantValue: 30},
+		{name: "rising slowly", op: ">", threshold: 10, window: 300, offsets: []int64{-120, 0}, values: []float64{0, 10}},
+		{name: "points outside window ignored", op: ">", threshold: 10, window: 300, offsets: []int64{-400, -100, 0}, values: []float64{0, 100, 100}},
+		{name: "falling fast", op: "<", threshold: -10, window: 300, offsets: []int64{-120, 0}, values: []float64{100, 0}, wantFired: true, wantValue: -50},
+		{name: "latest point stale", op: ">", threshold: 10, window: 900, offsets: []int64{-600, -360}, values: []float64{0, 100}},
+		{name: "duplicate timestamps", op: ">", threshold: 10, window: 300, offsets: []int64{0, 0}, values: []float64{0, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAlertStores(t)
+			appendPoints(t, d.ID, "level", now, tt.offsets, tt.values)
+			r := AlertRule{Kind: alertRate, Metric: "level", Op: tt.op, Threshold: tt.threshold, Window: tt.window}
+			fired, value, _ := r.evaluate(d, now)
+			if fired != tt.wantFired || value != tt.wantValue {
+				t.Errorf("evaluate = (%v, %g), want (%v, %g)", fired, value, tt.wantFired, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestAlertRuleEvaluateOffline(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rule := AlertRule{Kind: alertOffline, Duration: 300}
+
+	tests := []struct {
+		name       string
+		lastOnline int64 // 相对 now 的秒数
+		wantFired  bool
+	}{
+		{name: "online", lastOnline: -10},
+		{name: "offline shorter than duration", lastOnline: -200},
+		{name: "offline for duration", lastOnline: -300, wantFired: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Device{ID: "dev-1", Name: "sensor", LastOnline: now.Unix() + tt.lastOnline}
+			fired, value, _ := rule.evaluate(d, now)
+			if fired != tt.wantFired {
+				t.Fatalf("fired = %v, want %v", fired, tt.wantFired)
+			}
+			if fired && value != float64(-tt.lastOnline) {
+				t.Errorf("value = %g, want %d", value, -tt.lastOnline)
+			}
+		})
+	}
+}
+
+// 收集已发布的告警事件类型
+func drainAlertEvents(ch chan Event) []string {
+	var out []string
+	for {
+		select {
+		case e := <-ch:
+			if e.Type == "alert.firing" || e.Type == "alert.resolved" {
+				out = append(out, e.Type)
+			}
+		default:
+			return out
+		}
+	}
+}
+
+func alertStatuses(ruleID, deviceID string) []string {
+	var out []string
+	for _, a := range store.ListAlerts() {
+		if a.RuleID == ruleID && a.DeviceID == deviceID {
+			out = append(out, a.Status)
+		}
+	}
+	return out
+}
+
+func TestEvaluateAlertsFireResolveSilence(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := Device{ID: "dev-1", Name: "sensor", WorkspaceID: "ws-1", LastOnline: now.Unix()}
+	rule := AlertRule{ID: "ar-1", WorkspaceID: "ws-1", Kind: alertThreshold, Metric: "temp", Op: ">", Threshold: 50, Enabled: true}
+
+	tests := []struct {
+		name         string
+		silenced     bool
+		wantFiring   []string
+		wantResolved []string
+	}{
+		{name: "publishes events", wantFiring: []string{"alert.firing"}, wantResolved: []string{"alert.resolved"}},
+		{name: "silence suppresses events", silenced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAlertStores(t)
+			store.PutDevice(d)
+			if err := store.CreateAlertRule(rule); err != nil {
+				t.Fatal(err)
+			}
+			if tt.silenced {
+				store.PutAlertSilence(AlertSilence{RuleID: rule.ID, DeviceID: d.ID, WorkspaceID: "ws-1", Until: now.Unix() + 3600})
+			}
+			events, unsubscribe := subscribeEvents()
+			defer unsubscribe()
+
+			// 超限：触发一次，重复求值不产生新告警
+			appendPoints(t, d.ID, "temp", now, []int64{0}, []float64{60})
+			evaluateAlerts(now)
+			evaluateAlerts(now)
+			if got := alertStatuses(rule.ID, d.ID); len(got) != 1 || got[0] != alertFiring {
+				t.Fatalf("alerts after breach = %v, want one firing", got)
+			}
+			if got := drainAlertEvents(events); !reflect.DeepEqual(got, tt.wantFiring) {
+				t.Errorf("events after breach = %v, want %v", got, tt.wantFiring)
+			}
+
+			// 回落：告警恢复
+			later := now.Add(30 * time.Second)
+			appendPoints(t, d.ID, "temp", later, []int64{0}, []float64{40})
+			evaluateAlerts(later)
+			if got := alertStatuses(rule.ID, d.ID); len(got) != 1 || got[0] != alertResolved {
+				t.Fatalf("alerts after recovery = %v, want one resolved", got)
+			}
+			if got := drainAlertEvents(events); !reflect.DeepEqual(got, tt.wantResolved) {
+				t.Errorf("events after recovery = %v, want %v", got, tt.wantResolved)
+			}
+		})
+	}
+}
+
+// 规则停用后，未恢复的告警在下一轮求值时恢复
+func TestEvaluateAlertsResolvesDisabledRule(t *testing.T) {
+	stubAlertStores(t)
+	now := time.Unix(1700000000, 0)
+	d := Device{ID: "dev-1", Name: "sensor", WorkspaceID: "ws-1", LastOnline: now.Unix()}
+	store.PutDevice(d)
+	rule := AlertRule{ID: "ar-1", WorkspaceID: "ws-1", Kind: alertThreshold, Metric: "temp", Op: ">", Threshold: 50, Enabled: true}
+	if err := store.CreateAlertRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	appendPoints(t, d.ID, "temp", now, []int64{0}, []float64{60})
+	evaluateAlerts(now)
+
+	if _, err := store.UpdateAlertRule(rule.ID, func(r *AlertRule) error { r.Enabled = false; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	evaluateAlerts(now.Add(10 * time.Second))
+	if got := alertStatuses(rule.ID, d.ID); len(got) != 1 || got[0] != alertResolved {
+		t.Errorf("alerts after disabling rule = %v, want one resolved", got)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// API Key 供设备与 CI 脚本调用资源接口，通过 Authorization: Bearer cwk_... 传递
+const (
+	apiKeyPrefix = "cwk_"
+
+	apiKeyPersonal  = "personal"  // 以创建者身份访问，随创建者的工作区成员关系生效
+	apiKeyWorkspace = "workspace" // 绑定单个工作区，不依赖创建者身份
+
+	// 最近使用时间的落盘间隔，避免每次请求都写存储
+	apiKeyTouchInterval = time.Minute
+)
+
+// 资源 scope：<resource>:read | <resource>:write，write 包含 read
+var apiKeyScopes = map[string]bool{
+	"devices:read":    true,
+	"devices:write":   true,
+	"workflows:read":  true,
+	"workflows:write": true,
+}
+
+type APIKey struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"` // personal | workspace
+	Hash        string   `json:"hash"` // 令牌摘要，原文仅在创建时返回一次
+	Prefix      string   `json:"prefix"`
+	UserID      string   `json:"userId"`                // 创建者
+	WorkspaceID string   `json:"workspaceId,omitempty"` // workspace 类型绑定的工作区
+	Scopes      []string `json:"scopes"`
+	CreatedAt   int64    `json:"createdAt"`
+	ExpiresAt   int64    `json:"expiresAt,omitempty"` // 0 为不过期
+	LastUsedAt  int64    `json:"lastUsedAt,omitempty"`
+	RevokedAt   int64    `json:"revokedAt,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Kind          string   `json:"kind"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// 列表与创建响应，不包含摘要
+type APIKeyResponse struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Prefix      string   `json:"prefix"`
+	UserID      string   `json:"userId"`
+	WorkspaceID string   `json:"workspaceId,omitempty"`
+	Scopes      []string `json:"scopes"`
+	CreatedAt   int64    `json:"createdAt"`
+	ExpiresAt   int64    `json:"expiresAt,omitempty"`
+	LastUsedAt  int64    `json:"lastUsedAt,omitempty"`
+	RevokedAt   int64    `json:"revokedAt,omitempty"`
+	Key         string   `json:"key,omitempty"` // 仅创建时返回
+}
+
+func apiKeyResponse(k APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:          k.ID,
+		Name:        k.Name,
+		Kind:        k.Kind,
+		Prefix:      k.Prefix,
+		UserID:      k.UserID,
+		WorkspaceID: k.WorkspaceID,
+		Scopes:      k.Scopes,
+		CreatedAt:   k.CreatedAt,
+		ExpiresAt:   k.ExpiresAt,
+		LastUsedAt:  k.LastUsedAt,
+		RevokedAt:   k.RevokedAt,
+	}
+}
+
+func (k APIKey) allows(resource string, write bool) bool {
+	for _, s := range k.Scopes {
+		if s == resource+":write" || !write && s == resource+":read" {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验 API Key 并构造调用方；失败时已写入响应
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, token, resource string) (Principal, bool) {
+	k, ok := store.GetAPIKeyByHash(hashToken(token))
+	now := time.Now()
+	if !ok || k.RevokedAt != 0 || k.ExpiresAt != 0 && k.ExpiresAt <= now.Unix() {
+		writeUnauthorized(w)
+		return Principal{}, false
+	}
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if !k.allows(resource, write) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "API key scope does not allow this operation"})
+		return Principal{}, false
+	}
+
+	var p Principal
+	switch k.Kind {
+	case apiKeyWorkspace:
+		if h := strings.TrimSpace(r.Header.Get("X-Workspace-ID")); h != "" && h != k.WorkspaceID {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "Not a member of this workspace"})
+			return Principal{}, false
+		}
+		if _, ok := store.GetWorkspace(k.WorkspaceID); !ok {
+			writeUnauthorized(w)
+			return Principal{}, false
+		}
+		// 工作区 Key 由工作区管理员创建，用于 CI 等自动化场景：在所属工作区内按管理员处理，
+		// 可修改任何人创建的资源，实际可做的操作由 scope 限制；以自身作为新建资源的归属者。
+		// 工作区与 API Key 管理接口只接受会话令牌，Key 无法借此提升权限
+		p = Principal{UserID: "apikey:" + k.ID, Account: k.Name, Role: roleAdmin, WorkspaceID: k.WorkspaceID}
+	default:
+		user, ok := store.GetUser(k.UserID)
+		if !ok {
+			writeUnauthorized(w)
+			return Principal{}, false
+		}
+		wsID, role, err := resolveWorkspace(r, user)
+		if err != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "Not a member of this workspace"})
+			return Principal{}, false
+		}
+		p = Principal{UserID: user.ID, Account: user.Account, Role: role, UserRole: userRole(user), WorkspaceID: wsID}
+	}
+	p.APIKeyID = k.ID
+
+	if now.Unix()-k.LastUsedAt >= int64(apiKeyTouchInterval/time.Second) {
+		_, _ = store.UpdateAPIKey(k.ID, func(k *APIKey) error {
+			k.LastUsedAt = now.Unix()
+			return nil
+		})
+	}
+	return p, true
+}
+
+// 调用方可管理该 Key：个人 Key 的创建者，或工作区 Key 所属工作区的管理员
+func canManageAPIKey(p Principal, k APIKey) bool {
+	if k.Kind == apiKeyWorkspace {
+		role, ok := workspaceRole(p, k.WorkspaceID)
+		return ok && role == roleAdmin
+	}
+	return k.UserID == p.UserID
+}
+
+// GET /api/v1/api-keys (list), POST /api/v1/api-keys (create, key shown once)
+func apiKeysCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listAPIKeys(w, r)
+	case http.MethodPost:
+		createAPIKey(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// DELETE /api/v1/api-keys/{id} (revoke)
+func apiKeyResourceHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/api-keys/")
+	if id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		revokeAPIKey(w, r, id)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// 列出调用方的个人 Key，以及当前工作区的工作区 Key（仅管理员可见）
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	list := make([]APIKeyResponse, 0)
+	for _, k := range store.ListAPIKeys() {
+		if k.Kind == apiKeyWorkspace && k.WorkspaceID != p.WorkspaceID {
+			continue
+		}
+		if canManageAPIKey(p, k) {
+			list = append(list, apiKeyResponse(k))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"apiKeys": list, "total": len(list)})
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req CreateAPIKeyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "API key name is required"})
+		return
+	}
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if kind == "" {
+		kind = apiKeyPersonal
+	}
+	if kind != apiKeyPersonal && kind != apiKeyWorkspace {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Kind must be personal or workspace"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "At least one scope is required"})
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := map[string]bool{}
+	for _, s := range req.Scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !apiKeyScopes[s] {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Unknown scope: " + s})
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "expiresInDays must be positive"})
+		return
+	}
+
+	// 工作区 Key 需要当前工作区的管理员权限
+	wsID := ""
+	if kind == apiKeyWorkspace {
+		if p.WorkspaceID == "" || p.Role != roleAdmin {
+			writeForbidden(w)
+			return
+		}
+		wsID = p.WorkspaceID
+	}
+
+	now := time.Now()
+	token := apiKeyPrefix + randomToken(32)
+	k := APIKey{
+		ID:          fmt.Sprintf("ak-%d", store.NextSeq("apikey")),
+		Name:        name,
+		Kind:        kind,
+		Hash:        hashToken(token),
+		Prefix:      token[:len(apiKeyPrefix)+8],
+		UserID:      p.UserID,
+		WorkspaceID: wsID,
+		Scopes:      scopes,
+		CreatedAt:   now.Unix(),
+	}
+	if req.ExpiresInDays > 0 {
+		k.ExpiresAt = now.AddDate(0, 0, req.ExpiresInDays).Unix()
+	}
+	if err := store.CreateAPIKey(k); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "API key already exists"})
+		return
+	}
+
+	resp := apiKeyResponse(k)
+	resp.Key = token
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func revokeAPIKey(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if k, ok := store.GetAPIKey(id); !ok || !canManageAPIKey(p, k) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "API key not found"})
+		return
+	}
+	k, err := store.UpdateAPIKey(id, func(k *APIKey) error {
+		if k.RevokedAt == 0 {
+			k.RevokedAt = time.Now().Unix()
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "API key not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, apiKeyResponse(k))
+}
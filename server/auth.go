@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,6 +18,10 @@ type CreateSessionRequest struct {
 	Code     string `json:"code"`
 	MFA      string `json:"mfa"`
 	Remember bool   `json:"remember"`
+
+	// 连续登录失败后需要图形验证码
+	CaptchaID       string `json:"captchaId"`
+	CaptchaSolution string `json:"captchaSolution"`
 }
 
 type CreateUserRequest struct {
@@ -28,13 +34,17 @@ type CreateUserRequest struct {
 type CreateCodeRequest struct {
 	Account string `json:"account"`
 	Channel string `json:"channel"` // email | sms
+
+	CaptchaID       string `json:"captchaId"`       // POST /api/v1/auth/captchas 返回的 ID
+	CaptchaSolution string `json:"captchaSolution"` // 图片中的数字
 }
 
 // 认证相关响应结构
 type SessionResponse struct {
-	Token    string            `json:"token"`
-	User     map[string]string `json:"user"`
-	Remember bool              `json:"remember"`
+	Token     string            `json:"token,omitempty"`
+	User      map[string]string `json:"user"`
+	Remember  bool              `json:"remember"`
+	ExpiresAt int64             `json:"expiresAt"`
 }
 
 type CodeResponse struct {
@@ -47,6 +57,35 @@ type QRTicketResponse struct {
 	ExpiresAt int64  `json:"expiresAt"`
 }
 
+// 已注册用户（持久化结构，不直接作为响应返回）
+type User struct {
+	ID              string `json:"id"`
+	Account         string `json:"account"`
+	PasswordHash    string `json:"passwordHash"`              // bcrypt
+	CreatedAt       int64  `json:"createdAt"`
+	FailedLogins    int    `json:"failedLogins"`              // 连续登录失败次数
+	LockedUntil     int64  `json:"lockedUntil"`               // 锁定截止时间戳
+	Role            string `json:"role"`                      // admin | editor | viewer
+	ActiveWorkspace string `json:"activeWorkspace,omitempty"` // 最近切换到的工作区
+
+	// TOTP 二次验证
+	MFAEnabled        bool     `json:"mfaEnabled"`
+	TOTPSecret        string   `json:"totpSecret,omitempty"`        // base32
+	TOTPPendingSecret string   `json:"totpPendingSecret,omitempty"` // 绑定中、尚未确认的密钥
+	TOTPLastStep      int64    `json:"totpLastStep,omitempty"`      // 最近一次使用的时间步，防重放
+	RecoveryCodes     []string `json:"recoveryCodes,omitempty"`     // 恢复码摘要
+}
+
+// 登录会话；Key 为存储中的查找键
+type Session struct {
+	Key       string `json:"key"`
+	UserID    string `json:"userId"`
+	Account   string `json:"account"`
+	Remember  bool   `json:"remember"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
 // POST /api/v1/auth/sessions (login), DELETE /api/v1/auth/sessions (logout)
 func authSessionsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -59,6 +98,18 @@ func authSessionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /api/v1/auth/sessions/current (current session info)
+func authCurrentSessionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getCurrentSession(w, r)
+	case http.MethodDelete:
+		deleteSession(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
 // POST /api/v1/auth/users (register)
 func authUsersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -115,28 +166,75 @@ func createSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 密码长度验证
-	if len(req.Password) < 6 {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "密码太短(>=6)"})
+	// 多次登录失败后需先通过图形验证码
+	if loginNeedsCaptcha(req.Account) && !verifyCaptcha(req.CaptchaID, req.CaptchaSolution) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "请输入正确的图形验证码", "captchaRequired": true})
 		return
 	}
 
-	// 生成会话令牌
-	token := fmt.Sprintf("session-%d", time.Now().Unix())
-	
+	// 校验账号密码及二次验证码（连续失败会锁定账号）
+	user, err := authenticate(req.Account, req.Password, req.MFA)
+	if err == errBadCredentials || err == errBadMFA {
+		noteLoginFailure(req.Account)
+	}
+	captcha := loginNeedsCaptcha(req.Account)
+	switch err {
+	case nil:
+		clearLoginFailures(req.Account)
+	case errMFARequired:
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "请输入二次验证码", "mfaRequired": true})
+		return
+	case errBadMFA:
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "二次验证码错误", "mfaRequired": true, "captchaRequired": captcha})
+		return
+	case errAccountLocked:
+		w.Header().Set("Retry-After", strconv.FormatInt(user.LockedUntil-time.Now().Unix(), 10))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "登录失败次数过多，账号已临时锁定"})
+		return
+	case errBadCredentials:
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "账号或密码错误", "captchaRequired": captcha})
+		return
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "登录失败"})
+		return
+	}
+
+	// 生成会话令牌（随机令牌，服务端保存摘要与过期时间）
+	token, sess := issueSession(user.ID, user.Account, req.Remember)
+
 	response := SessionResponse{
-		Token:    token,
-		User:     map[string]string{"account": req.Account},
-		Remember: req.Remember,
+		Token:     token,
+		User:      map[string]string{"id": sess.UserID, "account": sess.Account, "role": userRole(user)},
+		Remember:  sess.Remember,
+		ExpiresAt: sess.ExpiresAt,
 	}
 
 	writeJSON(w, http.StatusCreated, response)
 }
 
-// 删除会话（登出）
+// 查询当前会话
+func getCurrentSession(w http.ResponseWriter, r *http.Request) {
+	sess, err := lookupSession(bearerToken(r))
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "未登录或会话已过期"})
+		return
+	}
+
+	user, _ := store.GetUser(sess.UserID)
+	response := SessionResponse{
+		User:      map[string]string{"id": sess.UserID, "account": sess.Account, "role": userRole(user)},
+		Remember:  sess.Remember,
+		ExpiresAt: sess.ExpiresAt,
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// 删除会话（登出）：吊销 Authorization header 中的令牌
 func deleteSession(w http.ResponseWriter, r *http.Request) {
-	// 在真实实现中，这里会验证 Authorization header 并删除对应的会话
-	// 目前是 mock 实现，直接返回成功
+	if !revokeSession(bearerToken(r)) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "未登录或会话已过期"})
+		return
+	}
 	writeJSON(w, http.StatusNoContent, nil)
 }
 
@@ -171,9 +269,35 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "密码太短(>=6)"})
 		return
 	}
+	if len(req.Password) > maxPasswordBytes {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "密码太长(<=72字节)"})
+		return
+	}
+
+	// 邮箱已注册时不消费验证码
+	if _, exists := store.GetUserByAccount(normalizeAccount(req.Account)); exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "该邮箱已注册"})
+		return
+	}
+
+	// 校验验证码（错误、过期、已使用均拒绝）
+	if err := consumeCode("register", req.Account, strings.TrimSpace(req.Code)); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": codeErrorMessage(err)})
+		return
+	}
+
+	// 创建用户（密码以 bcrypt 摘要保存，邮箱唯一）
+	user, err := registerUser(req.Account, req.Password)
+	if err == errConflict {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "该邮箱已注册"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "注册失败"})
+		return
+	}
 
-	// 在真实实现中，这里会验证验证码并创建用户
-	writeJSON(w, http.StatusCreated, map[string]string{"message": "注册成功", "userId": fmt.Sprintf("user-%d", time.Now().Unix())})
+	writeJSON(w, http.StatusCreated, map[string]string{"message": "注册成功", "userId": user.ID, "role": user.Role})
 }
 
 // 创建验证码（发送验证码）
@@ -202,12 +326,34 @@ func createCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 生成验证码 ID
-	codeId := fmt.Sprintf("code-%d", time.Now().UnixNano())
-	
+	// 发送前必须通过图形验证码，防止接口被刷
+	if !verifyCaptcha(req.CaptchaID, req.CaptchaSolution) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": "图形验证码错误或已过期", "captchaRequired": true})
+		return
+	}
+
+	// 生成验证码（服务端保存摘要，带有效期与重发冷却）
+	code, codeID, wait, err := issueCode("register", req.Account)
+	if err == errCodeCooldown {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+0.5)))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "发送过于频繁，请稍后再试"})
+		return
+	}
+	data := map[string]interface{}{
+		"Code":       code,
+		"HiddenCode": hideCode(code),
+		"TTLMinutes": int(cfg.CodeTTL.Minutes()),
+	}
+	if err := sendTemplateMail(req.Account, "verification_code", data); err != nil {
+		revokeCode("register", req.Account)
+		log.Printf("auth: send verification code to %s failed: %v", req.Account, err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "验证码发送失败，请稍后重试"})
+		return
+	}
+
 	response := CodeResponse{
 		Message: fmt.Sprintf("验证码已发送至 %s", req.Account),
-		CodeID:  codeId,
+		CodeID:  codeID,
 	}
 
 	writeJSON(w, http.StatusCreated, response)
@@ -215,13 +361,12 @@ func createCode(w http.ResponseWriter, r *http.Request) {
 
 // 创建二维码票据
 func createQRTicket(w http.ResponseWriter, r *http.Request) {
-	// 生成二维码票据
-	ticket := fmt.Sprintf("qr-ticket-%d", time.Now().UnixNano())
-	expiresAt := time.Now().Add(5 * time.Minute).Unix() // 5分钟过期
-	
+	// 生成二维码票据（随机 ID，QR_TICKET_TTL 后过期）
+	t := newQRTicket()
+
 	response := QRTicketResponse{
-		Ticket:    ticket,
-		ExpiresAt: expiresAt,
+		Ticket:    t.ID,
+		ExpiresAt: t.ExpiresAt.Unix(),
 	}
 
 	writeJSON(w, http.StatusCreated, response)
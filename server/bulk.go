@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 单次批量操作最多涉及的设备数
+const maxBulkDevices = 1000
+
+// 批量操作的目标设备，deviceIds、groupId 与 labels 三选一
+type bulkTarget struct {
+	DeviceIDs []string `json:"deviceIds"`
+	GroupID   string   `json:"groupId"`
+	Labels    string   `json:"labels"` // 标签选择器，如 site=sh,floor=3
+}
+
+type BulkCreateRequest struct {
+	Devices []CreateDeviceRequest `json:"devices"`
+}
+
+type BulkUpdateRequest struct {
+	bulkTarget
+	Type      string             `json:"type"`
+	SetLabels map[string]*string `json:"setLabels"` // 按键合并，值为 null 删除
+}
+
+type BulkDeleteRequest struct {
+	bulkTarget
+}
+
+type BulkCommandRequest struct {
+	bulkTarget
+	CreateCommandRequest
+}
+
+// 单个设备的处理结果；Index 为设备在请求（批量创建）或目标列表中的位置
+type BulkResult struct {
+	Index   int             `json:"index"`
+	ID      string          `json:"id,omitempty"`
+	Status  int             `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Device  *DeviceResponse `json:"device,omitempty"`
+	Command *DeviceCommand  `json:"command,omitempty"`
+
+	EnrollmentToken string `json:"enrollmentToken,omitempty"` // 批量创建时签发
+}
+
+type BulkResponse struct {
+	Results   []BulkResult `json:"results"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+func (b *BulkResponse) add(res BulkResult) {
+	res.Index = len(b.Results)
+	if res.Status < 300 {
+		b.Succeeded++
+	} else {
+		b.Failed++
+	}
+	b.Results = append(b.Results, res)
+}
+
+func (b *BulkResponse) fail(id string, status int, msg string) {
+	b.add(BulkResult{ID: id, Status: status, Error: msg})
+}
+
+// 解析目标设备 ID 列表（去重、保持顺序）；是否属于当前工作区由各操作逐个检查
+func resolveBulkTarget(p Principal, t bulkTarget) ([]string, error) {
+	set := 0
+	if len(t.DeviceIDs) > 0 {
+		set++
+	}
+	if strings.TrimSpace(t.GroupID) != "" {
+		set++
+	}
+	if strings.TrimSpace(t.Labels) != "" {
+		set++
+	}
+	if set != 1 {
+		return nil, errors.New("Exactly one of deviceIds, groupId or labels is required")
+	}
+
+	var ids []string
+	switch {
+	case len(t.DeviceIDs) > 0:
+		seen := map[string]bool{}
+		for _, id := range t.DeviceIDs {
+			if id = strings.TrimSpace(id); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	case strings.TrimSpace(t.GroupID) != "":
+		g, ok := store.GetDeviceGroup(strings.TrimSpace(t.GroupID))
+		if !ok || g.WorkspaceID != p.WorkspaceID {
+			return nil, errors.New("Device group not found")
+		}
+		for _, d := range groupDevices(g) {
+			ids = append(ids, d.ID)
+		}
+	default:
+		sel, err := parseLabelSelector(t.Labels)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range store.ListDevices() {
+			if d.WorkspaceID == p.WorkspaceID && sel.matches(d.Labels) {
+				ids = append(ids, d.ID)
+			}
+		}
+		sort.Strings(ids)
+	}
+	if len(ids) > maxBulkDevices {
+		return nil, fmt.Errorf("A bulk operation can target at most %d devices", maxBulkDevices)
+	}
+	return ids, nil
+}
+
+// POST /api/v1/devices/bulk/create|update|delete|commands
+func devicesBulkHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/api/v1/devices/bulk/")
+	handlers := map[string]func(http.ResponseWriter, Principal, []byte){
+		"create":   bulkCreateDevices,
+		"update":   bulkUpdateDevices,
+		"delete":   bulkDeleteDevices,
+		"commands": bulkDispatchCommands,
+	}
+	h, ok := handlers[action]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	h(w, p, body)
+}
+
+func bulkCreateDevices(w http.ResponseWriter, p Principal, body []byte) {
+	var req BulkCreateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if len(req.Devices) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "At least one device is required"})
+		return
+	}
+	if len(req.Devices) > maxBulkDevices {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("A bulk operation can target at most %d devices", maxBulkDevices)})
+		return
+	}
+
+	// 先整体校验，再一次性分配 ID 并写入存储
+	devices := make([]Device, len(req.Devices))
+	errs := make([]error, len(req.Devices))
+	var valid []Device
+	for i, item := range req.Devices {
+		devices[i], errs[i] = buildDevice(p, item)
+		if errs[i] == nil {
+			valid = append(valid, devices[i])
+		}
+	}
+	var created []createdDevice
+	if len(valid) > 0 {
+		first := store.NextSeqRange("device", len(valid))
+		for i := range valid {
+			valid[i].ID = deviceID(first + i)
+		}
+		created = createDevices(p, valid)
+	}
+
+	resp := BulkResponse{Results: make([]BulkResult, 0, len(req.Devices))}
+	now := time.Now()
+	for i := range req.Devices {
+		if errs[i] != nil {
+			resp.fail("", http.StatusUnprocessableEntity, errs[i].Error())
+			continue
+		}
+		d, c := valid[0], created[0]
+		valid, created = valid[1:], created[1:]
+		dr := withOnline(d, now)
+		resp.add(BulkResult{ID: d.ID, Status: http.StatusCreated, Device: &dr, EnrollmentToken: c.token})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 批量修改类型与标签
+func bulkUpdateDevices(w http.ResponseWriter, p Principal, body []byte) {
+	var req BulkUpdateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	typ := strings.TrimSpace(req.Type)
+	lp, err := parseLabelPatch(req.SetLabels)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	if typ == "" && lp.empty() {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Nothing to update: provide type or setLabels"})
+		return
+	}
+	ids, err := resolveBulkTarget(p, req.bulkTarget)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	before := map[string]Device{}
+	updated, errs := store.UpdateDevices(ids, func(d *Device) error {
+		if d.WorkspaceID != p.WorkspaceID {
+			return errNotFound
+		}
+		if !canModify(p, d.OwnerID) {
+			return errForbidden
+		}
+		before[d.ID] = *d
+		if !lp.empty() {
+			labels, err := lp.apply(d.Labels)
+			if err != nil {
+				return err
+			}
+			d.Labels = labels
+		}
+		if typ != "" {
+			d.Type = typ
+		}
+		d.UpdatedAt = now.Unix()
+		return nil
+	})
+
+	resp := BulkResponse{Results: make([]BulkResult, 0, len(ids))}
+	var h historyBatch
+	for i, id := range ids {
+		d, err := updated[i], errs[i]
+		switch {
+		case err == errNotFound:
+			resp.fail(id, http.StatusNotFound, "Device not found")
+		case err == errForbidden:
+			resp.fail(id, http.StatusForbidden, "Forbidden")
+		case err != nil:
+			resp.fail(id, http.StatusUnprocessableEntity, err.Error())
+		default:
+			h.add(p, historyUpdate, d, deviceChanges(before[id], d))
+			dr := withOnline(d, now)
+			resp.add(BulkResult{ID: id, Status: http.StatusOK, Device: &dr})
+		}
+	}
+	if entries := h.entries(); len(entries) > 0 {
+		store.AppendDeviceHistory(entries...)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func bulkDeleteDevices(w http.ResponseWriter, p Principal, body []byte) {
+	var req BulkDeleteRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	ids, err := resolveBulkTarget(p, req.bulkTarget)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// 先逐个检查权限，再一次性删除允许的设备
+	status := make([]int, len(ids))
+	var allowed []string
+	for i, id := range ids {
+		d, ok := workspaceDevice(p, id)
+		switch {
+		case !ok:
+			status[i] = http.StatusNotFound
+		case !canModify(p, d.OwnerID):
+			status[i] = http.StatusForbidden
+		default:
+			allowed = append(allowed, id)
+		}
+	}
+	removed := removeDevices(p, allowed)
+
+	resp := BulkResponse{Results: make([]BulkResult, 0, len(ids))}
+	for i, id := range ids {
+		switch status[i] {
+		case http.StatusNotFound:
+			resp.fail(id, http.StatusNotFound, "Device not found")
+		case http.StatusForbidden:
+			resp.fail(id, http.StatusForbidden, "Forbidden")
+		default:
+			ok := removed[0]
+			removed = removed[1:]
+			if !ok {
+				resp.fail(id, http.StatusNotFound, "Device not found")
+				continue
+			}
+			resp.add(BulkResult{ID: id, Status: http.StatusNoContent})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 向每个目标设备的指令队列各下发一条指令
+func bulkDispatchCommands(w http.ResponseWriter, p Principal, body []byte) {
+	var req BulkCommandRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	// 指令参数对所有设备相同，先整体校验
+	if _, err := newCommand(p, "", req.CreateCommandRequest); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	ids, err := resolveBulkTarget(p, req.bulkTarget)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp := BulkResponse{Results: make([]BulkResult, 0, len(ids))}
+	for _, id := range ids {
+		if _, ok := workspaceDevice(p, id); !ok {
+			resp.fail(id, http.StatusNotFound, "Device not found")
+			continue
+		}
+		c, _ := newCommand(p, id, req.CreateCommandRequest)
+		c = enqueueCommand(c)
+		resp.add(BulkResult{ID: id, Status: http.StatusCreated, Command: &c})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/captcha"
+)
+
+// 图形验证码的生成、渲染与校验使用 dchest/captcha，挑战数据保存在下方带有效期的内存表中
+const (
+	captchaWidth  = captcha.StdWidth
+	captchaHeight = captcha.StdHeight
+	captchaLength = 6
+
+	maxLoginFailEntries = 10000 // 登录失败计数表的上限，超出时淘汰最早过期的记录
+)
+
+type captchaChallenge struct {
+	Digits    []byte
+	ExpiresAt time.Time
+}
+
+type loginFailure struct {
+	Count     int
+	ExpiresAt time.Time // 最后一次失败后 cfg.LoginFailureWindow
+}
+
+type CaptchaResponse struct {
+	CaptchaID string `json:"captchaId"`
+	ImageURL  string `json:"imageUrl"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// ---- In-memory store for captcha challenges ----
+var (
+	captchaMu  sync.Mutex
+	captchas   = map[string]*captchaChallenge{}
+	loginFails = map[string]*loginFailure{} // 账号 -> 连续登录失败次数（不区分账号是否存在）
+)
+
+func init() {
+	captcha.SetCustomStore(captchaStore{})
+}
+
+// 实现 captcha.Store：按 cfg.CaptchaTTL 过期，重新生成数字（Reload）不延长有效期
+type captchaStore struct{}
+
+// 过期挑战由 captchaJanitor 清理
+func (captchaStore) Set(id string, digits []byte) {
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	if c, ok := captchas[id]; ok {
+		c.Digits = digits
+		return
+	}
+	captchas[id] = &captchaChallenge{Digits: digits, ExpiresAt: time.Now().Add(cfg.CaptchaTTL)}
+}
+
+func (captchaStore) Get(id string, clear bool) []byte {
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	c, ok := captchas[id]
+	if !ok {
+		return nil
+	}
+	expired := time.Now().After(c.ExpiresAt)
+	if clear || expired {
+		delete(captchas, id)
+	}
+	if expired {
+		return nil
+	}
+	return c.Digits
+}
+
+func captchaExpiresAt(id string) (time.Time, bool) {
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	c, ok := captchas[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	return c.ExpiresAt, true
+}
+
+// 校验图形验证码；无论成功与否都作废，防止重复尝试
+func verifyCaptcha(id, solution string) bool {
+	return captcha.VerifyString(id, strings.TrimSpace(solution))
+}
+
+// 登录失败达到 cfg.LoginCaptchaAfter 次后要求图形验证码
+func loginNeedsCaptcha(account string) bool {
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	f, ok := loginFails[normalizeAccount(account)]
+	return ok && time.Now().Before(f.ExpiresAt) && f.Count >= cfg.LoginCaptchaAfter
+}
+
+func noteLoginFailure(account string) {
+	key := normalizeAccount(account)
+	now := time.Now()
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	f, ok := loginFails[key]
+	if !ok || now.After(f.ExpiresAt) {
+		if !ok && len(loginFails) >= maxLoginFailEntries {
+			evictLoginFailures(now)
+		}
+		f = &loginFailure{}
+		loginFails[key] = f
+	}
+	f.Count++
+	f.ExpiresAt = now.Add(cfg.LoginFailureWindow)
+}
+
+// 表已满时先清理过期记录，仍满则淘汰最早过期的一条；调用方需持有 captchaMu
+func evictLoginFailures(now time.Time) {
+	sweepLoginFailures(now)
+	if len(loginFails) < maxLoginFailEntries {
+		return
+	}
+	var oldest string
+	for k, f := range loginFails {
+		if oldest == "" || f.ExpiresAt.Before(loginFails[oldest].ExpiresAt) {
+			oldest = k
+		}
+	}
+	delete(loginFails, oldest)
+}
+
+// 调用方需持有 captchaMu
+func sweepLoginFailures(now time.Time) {
+	for k, f := range loginFails {
+		if now.After(f.ExpiresAt) {
+			delete(loginFails, k)
+		}
+	}
+}
+
+// 定期清理过期的图形验证码与登录失败计数
+func captchaJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		captchaMu.Lock()
+		for k, c := range captchas {
+			if now.After(c.ExpiresAt) {
+				delete(captchas, k)
+			}
+		}
+		sweepLoginFailures(now)
+		captchaMu.Unlock()
+	}
+}
+
+func clearLoginFailures(account string) {
+	captchaMu.Lock()
+	delete(loginFails, normalizeAccount(account))
+	captchaMu.Unlock()
+}
+
+// POST /api/v1/auth/captchas (new challenge)
+func authCaptchasHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		createCaptcha(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET /api/v1/auth/captchas/{id}.png (image), ?reload=1 重新生成数字
+func captchaImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/auth/captchas/")
+	if !strings.HasSuffix(name, ".png") || strings.Contains(name, "/") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	id := strings.TrimSuffix(name, ".png")
+
+	if r.URL.Query().Get("reload") != "" {
+		captcha.Reload(id)
+	}
+	var buf bytes.Buffer
+	if err := captcha.WriteImage(&buf, id, captchaWidth, captchaHeight); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "图形验证码不存在或已过期"})
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func createCaptcha(w http.ResponseWriter, r *http.Request) {
+	id := captcha.NewLen(captchaLength)
+	expiresAt, _ := captchaExpiresAt(id)
+	writeJSON(w, http.StatusCreated, CaptchaResponse{
+		CaptchaID: id,
+		ImageURL:  "/api/v1/auth/captchas/" + id + ".png",
+		ExpiresAt: expiresAt.Unix(),
+	})
+}
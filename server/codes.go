@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	errCodeCooldown        = errors.New("code requested too frequently")
+	errCodeNotFound        = errors.New("code not requested")
+	errCodeInvalid         = errors.New("code is wrong")
+	errCodeExpired         = errors.New("code expired")
+	errCodeUsed            = errors.New("code already used")
+	errCodeTooManyAttempts = errors.New("too many wrong attempts")
+)
+
+// 已发送的验证码，仅保存摘要
+type verificationCode struct {
+	ID        string
+	Hash      string
+	SentAt    time.Time
+	ExpiresAt time.Time
+	Attempts  int
+	Used      bool
+}
+
+// ---- In-memory store for verification codes, keyed by purpose + account ----
+var (
+	codesMu sync.Mutex
+	codes   = map[string]*verificationCode{}
+)
+
+// 生成一个 6 位数字验证码
+func genCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func codeKey(purpose, account string) string {
+	return purpose + ":" + normalizeAccount(account)
+}
+
+func hashCode(key, code string) string {
+	sum := sha256.Sum256([]byte(key + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// 签发验证码，返回验证码原文与 ID；冷却期内重复请求返回 errCodeCooldown 及剩余等待时间
+func issueCode(purpose, account string) (string, string, time.Duration, error) {
+	key := codeKey(purpose, account)
+	now := time.Now()
+
+	codesMu.Lock()
+	defer codesMu.Unlock()
+
+	if c, ok := codes[key]; ok {
+		if wait := c.SentAt.Add(cfg.CodeResendCooldown).Sub(now); wait > 0 {
+			return "", "", wait, errCodeCooldown
+		}
+	}
+	// 顺带清理过期验证码
+	for k, c := range codes {
+		if now.After(c.ExpiresAt) {
+			delete(codes, k)
+		}
+	}
+
+	code := genCode()
+	c := &verificationCode{
+		ID:        "code_" + randomToken(9),
+		Hash:      hashCode(key, code),
+		SentAt:    now,
+		ExpiresAt: now.Add(cfg.CodeTTL),
+	}
+	codes[key] = c
+	return code, c.ID, 0, nil
+}
+
+// 作废验证码（如发送失败），允许立即重新获取
+func revokeCode(purpose, account string) {
+	codesMu.Lock()
+	delete(codes, codeKey(purpose, account))
+	codesMu.Unlock()
+}
+
+// 校验并消费验证码；错误次数达到上限后验证码作废
+func consumeCode(purpose, account, code string) error {
+	key := codeKey(purpose, account)
+
+	codesMu.Lock()
+	defer codesMu.Unlock()
+
+	c, ok := codes[key]
+	switch {
+	case !ok:
+		return errCodeNotFound
+	case c.Used:
+		return errCodeUsed
+	case time.Now().After(c.ExpiresAt):
+		return errCodeExpired
+	case c.Attempts >= cfg.CodeMaxAttempts:
+		return errCodeTooManyAttempts
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(hashCode(key, code))) != 1 {
+		c.Attempts++
+		if c.Attempts >= cfg.CodeMaxAttempts {
+			return errCodeTooManyAttempts
+		}
+		return errCodeInvalid
+	}
+	c.Used = true
+	return nil
+}
+
+// 验证码错误对应的提示
+func codeErrorMessage(err error) string {
+	switch err {
+	case errCodeNotFound:
+		return "请先获取验证码"
+	case errCodeInvalid:
+		return "验证码错误"
+	case errCodeExpired:
+		return "验证码已过期，请重新获取"
+	case errCodeUsed:
+		return "验证码已使用，请重新获取"
+	case errCodeTooManyAttempts:
+		return "验证码错误次数过多，请重新获取"
+	default:
+		return "验证码校验失败"
+	}
+}
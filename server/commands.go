@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 设备指令状态：pending -> delivered -> succeeded | failed；超过 TTL 未完成为 expired
+const (
+	cmdPending   = "pending"
+	cmdDelivered = "delivered"
+	cmdSucceeded = "succeeded"
+	cmdFailed    = "failed"
+	cmdExpired   = "expired"
+
+	cmdMaxTTL    = 24 * time.Hour
+	cmdKeepAfter = 24 * time.Hour // 已结束的指令保留时长
+)
+
+type DeviceCommand struct {
+	ID          string          `json:"id"`
+	DeviceID    string          `json:"deviceId"`
+	WorkspaceID string          `json:"workspaceId"`
+	Name        string          `json:"name"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CreatedBy   string          `json:"createdBy"`
+	CreatedAt   int64           `json:"createdAt"`
+	ExpiresAt   int64           `json:"expiresAt"`
+	DeliveredAt int64           `json:"deliveredAt,omitempty"`
+	CompletedAt int64           `json:"completedAt,omitempty"`
+
+	seq int // 入队顺序，设备按此顺序拉取；取自指令 ID 的序号
+}
+
+type CreateCommandRequest struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+	TTL     int             `json:"ttl"` // 秒，默认 COMMAND_TTL
+}
+
+type AckCommandRequest struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+}
+
+// ---- Device command queue ----
+// 队列在内存中维护，每次变更同时写入存储，启动时由 loadCommands 恢复；指令 ID 取自 store.NextSeq
+var (
+	cmdsMu     sync.Mutex
+	commands   = map[string]*DeviceCommand{}
+	cmdSignals = map[string]chan struct{}{} // 设备 ID -> 新指令入队时关闭，唤醒长轮询
+)
+
+// 从存储恢复指令队列，启动时调用一次
+func loadCommands() {
+	cmdsMu.Lock()
+	defer cmdsMu.Unlock()
+	commands = map[string]*DeviceCommand{}
+	for _, c := range store.ListDeviceCommands() {
+		c := c
+		fmt.Sscanf(c.ID, "cmd-%d", &c.seq)
+		commands[c.ID] = &c
+	}
+}
+
+// 调用方需持有 cmdsMu；超时未完成的指令转为 expired
+func (c *DeviceCommand) refresh(now time.Time) {
+	if (c.Status == cmdPending || c.Status == cmdDelivered) && now.Unix() >= c.ExpiresAt {
+		c.Status = cmdExpired
+		c.CompletedAt = c.ExpiresAt
+		store.PutDeviceCommand(*c)
+		publishEvent(Event{Type: "command.expired", DeviceID: c.DeviceID, WorkspaceID: c.WorkspaceID, Data: map[string]interface{}{"commandId": c.ID}})
+	}
+}
+
+// 调用方需持有 cmdsMu
+func cmdSignal(deviceID string) chan struct{} {
+	ch, ok := cmdSignals[deviceID]
+	if !ok {
+		ch = make(chan struct{})
+		cmdSignals[deviceID] = ch
+	}
+	return ch
+}
+
+func enqueueCommand(c DeviceCommand) DeviceCommand {
+	now := time.Now()
+	cmdsMu.Lock()
+	defer cmdsMu.Unlock()
+	// 顺带清理结束较久的指令
+	var stale []string
+	for id, old := range commands {
+		old.refresh(now)
+		if old.CompletedAt != 0 && now.Unix()-old.CompletedAt > int64(cmdKeepAfter/time.Second) {
+			delete(commands, id)
+			stale = append(stale, id)
+		}
+	}
+	store.DeleteDeviceCommands(stale...)
+	c.seq = store.NextSeq("command")
+	c.ID = fmt.Sprintf("cmd-%d", c.seq)
+	c.Status = cmdPending
+	commands[c.ID] = &c
+	store.PutDeviceCommand(c)
+
+	close(cmdSignal(c.DeviceID))
+	delete(cmdSignals, c.DeviceID)
+	return c
+}
+
+// 删除设备时丢弃其指令
+func dropDeviceCommands(deviceID string) {
+	cmdsMu.Lock()
+	defer cmdsMu.Unlock()
+	var dropped []string
+	for id, c := range commands {
+		if c.DeviceID == deviceID {
+			delete(commands, id)
+			dropped = append(dropped, id)
+		}
+	}
+	store.DeleteDeviceCommands(dropped...)
+	if ch, ok := cmdSignals[deviceID]; ok {
+		close(ch)
+		delete(cmdSignals, deviceID)
+	}
+}
+
+// 按 ID 读取指令快照，状态已按 TTL 刷新
+func lookupCommand(cmdID string) (DeviceCommand, bool) {
+	cmdsMu.Lock()
+	defer cmdsMu.Unlock()
+	c, ok := commands[cmdID]
+	if !ok {
+		return DeviceCommand{}, false
+	}
+	c.refresh(time.Now())
+	return *c, true
+}
+
+// 丢弃尚未被设备拉取的指令，返回实际丢弃的指令 ID
+func dropPendingCommands(ids []string) map[string]bool {
+	now := time.Now()
+	dropped := map[string]bool{}
+	cmdsMu.Lock()
+	defer cmdsMu.Unlock()
+	for _, id := range ids {
+		c, ok := commands[id]
+		if !ok {
+			continue
+		}
+		c.refresh(now)
+		if c.Status == cmdPending {
+			delete(commands, id)
+			dropped[id] = true
+			store.DeleteDeviceCommands(id)
+		}
+	}
+	return dropped
+}
+
+// GET/POST /api/v1/devices/{id}/commands, POST /api/v1/devices/{id}/commands/pull
+// GET /api/v1/devices/{id}/commands/{cmdId}, POST /api/v1/devices/{id}/commands/{cmdId}/ack
+func deviceCommandsHandler(w http.ResponseWriter, r *http.Request, id string, parts []string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := workspaceDevice(p, id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+
+	if len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		listCommands(w, r, id)
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		createCommand(w, r, id)
+	case len(parts) == 1 && parts[0] == "pull" && r.Method == http.MethodPost:
+		pullCommand(w, r, id)
+	case len(parts) == 1 && parts[0] != "pull" && r.Method == http.MethodGet:
+		getCommand(w, r, id, parts[0])
+	case len(parts) == 2 && parts[1] == "ack" && r.Method == http.MethodPost:
+		ackCommand(w, r, id, parts[0])
+	case len(parts) <= 1 || len(parts) == 2 && parts[1] == "ack":
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+func createCommand(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req CreateCommandRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	c, err := newCommand(p, id, req)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, enqueueCommand(c))
+}
+
+// 校验请求并构造待入队的指令；单个下发与批量下发共用
+func newCommand(p Principal, deviceID string, req CreateCommandRequest) (DeviceCommand, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return DeviceCommand{}, errors.New("Command name is required")
+	}
+	if req.TTL < 0 {
+		return DeviceCommand{}, errors.New("ttl must be positive")
+	}
+	ttl := cfg.CommandTTL
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > cmdMaxTTL {
+		return DeviceCommand{}, errors.New("ttl must not exceed 24h")
+	}
+
+	d, _ := store.GetDevice(deviceID)
+	now := time.Now()
+	return DeviceCommand{
+		DeviceID:    deviceID,
+		WorkspaceID: d.WorkspaceID,
+		Name:        name,
+		Payload:     req.Payload,
+		CreatedBy:   p.UserID,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(ttl).Unix(),
+	}, nil
+}
+
+// ?status= 过滤，最新的在前
+func listCommands(w http.ResponseWriter, r *http.Request, id string) {
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	now := time.Now()
+
+	cmdsMu.Lock()
+	list := make([]DeviceCommand, 0)
+	for _, c := range commands {
+		if c.DeviceID != id {
+			continue
+		}
+		c.refresh(now)
+		if status == "" || c.Status == status {
+			list = append(list, *c)
+		}
+	}
+	cmdsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].seq > list[j].seq })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"commands": list, "total": len(list)})
+}
+
+func getCommand(w http.ResponseWriter, r *http.Request, id, cmdID string) {
+	cmdsMu.Lock()
+	c, ok := commands[cmdID]
+	var snapshot DeviceCommand
+	if ok {
+		c.refresh(time.Now())
+		snapshot = *c
+	}
+	cmdsMu.Unlock()
+
+	if !ok || snapshot.DeviceID != id {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Command not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// 设备拉取最早的待执行指令并标记为 delivered；?wait=<秒> 长轮询（最长 60s），无指令时返回 204
+func pullCommand(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+	wait := 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && v > 0 {
+		wait = v
+	}
+	if wait > 60 {
+		wait = 60
+	}
+	deadline := time.NewTimer(time.Duration(wait) * time.Second)
+	defer deadline.Stop()
+
+	for {
+		now := time.Now()
+		cmdsMu.Lock()
+		var next *DeviceCommand
+		for _, c := range commands {
+			if c.DeviceID != id {
+				continue
+			}
+			c.refresh(now)
+			if c.Status == cmdPending && (next == nil || c.seq < next.seq) {
+				next = c
+			}
+		}
+		if next != nil {
+			next.Status = cmdDelivered
+			next.DeliveredAt = now.Unix()
+			store.PutDeviceCommand(*next)
+			snapshot := *next
+			cmdsMu.Unlock()
+			writeJSON(w, http.StatusOK, snapshot)
+			return
+		}
+		signal := cmdSignal(id)
+		cmdsMu.Unlock()
+
+		select {
+		case <-signal:
+		case <-deadline.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// 设备回报执行结果
+func ackCommand(w http.ResponseWriter, r *http.Request, id, cmdID string) {
+	p, _ := currentPrincipal(r)
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req AckCommandRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	now := time.Now()
+	cmdsMu.Lock()
+	c, ok := commands[cmdID]
+	if !ok || c.DeviceID != id {
+		cmdsMu.Unlock()
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Command not found"})
+		return
+	}
+	c.refresh(now)
+	if c.Status != cmdPending && c.Status != cmdDelivered {
+		status := c.Status
+		cmdsMu.Unlock()
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Command is already " + status})
+		return
+	}
+	if c.DeliveredAt == 0 {
+		c.DeliveredAt = now.Unix()
+	}
+	c.Status = cmdFailed
+	if req.Success {
+		c.Status = cmdSucceeded
+	}
+	c.Result = req.Result
+	c.Error = strings.TrimSpace(req.Error)
+	c.CompletedAt = now.Unix()
+	store.PutDeviceCommand(*c)
+	snapshot := *c
+	cmdsMu.Unlock()
+
+	publishEvent(Event{
+		Type:        "command." + snapshot.Status,
+		DeviceID:    id,
+		WorkspaceID: snapshot.WorkspaceID,
+		Data:        map[string]interface{}{"commandId": snapshot.ID, "name": snapshot.Name},
+	})
+	writeJSON(w, http.StatusOK, snapshot)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务端配置，统一从环境变量读取
+type Config struct {
+	RunWorkers    int    // 工作流执行并发度（WORKFLOW_RUN_WORKERS）
+	RunMaxWorkers int    // 单次运行可指定的最大并发度（WORKFLOW_RUN_MAX_WORKERS）
+	StoreDriver   string // 存储实现：memory | file（STORE_DRIVER）
+	StorePath     string // 文件存储路径（STORE_PATH）
+
+	StoreFlushInterval time.Duration // 文件存储两次写盘的最小间隔，期间的变更合并写入（STORE_FLUSH_INTERVAL）
+
+	SessionTTL  time.Duration // 普通会话有效期（SESSION_TTL）
+	RememberTTL time.Duration // “记住我”会话有效期（SESSION_REMEMBER_TTL）
+
+	AdminAccount string // 启动时及注册时设为管理员的账号，用于已有用户但没有管理员的旧数据（ADMIN_ACCOUNT）
+
+	LoginMaxFailures  int           // 连续登录失败锁定阈值（LOGIN_MAX_FAILURES）
+	LoginLockout      time.Duration // 锁定时长（LOGIN_LOCKOUT）
+	LoginCaptchaAfter int           // 连续失败多少次后登录需图形验证码（LOGIN_CAPTCHA_AFTER）
+
+	LoginFailureWindow time.Duration // 最后一次登录失败后超过该时长，图形验证码所用的失败计数清零（LOGIN_FAILURE_WINDOW）
+
+	CodeTTL            time.Duration // 验证码有效期（CODE_TTL）
+	CodeResendCooldown time.Duration // 重发冷却（CODE_RESEND_COOLDOWN）
+	CodeMaxAttempts    int           // 单个验证码最多尝试次数（CODE_MAX_ATTEMPTS）
+
+	DeviceOnlineTimeout time.Duration // 超过该时长无心跳视为离线（DEVICE_ONLINE_TIMEOUT）
+	DeviceSweepInterval time.Duration // 在线状态巡检间隔（DEVICE_SWEEP_INTERVAL）
+	CommandTTL          time.Duration // 设备指令默认有效期（COMMAND_TTL）
+	DeviceEnrollmentTTL time.Duration // 设备注册令牌有效期（DEVICE_ENROLLMENT_TTL）
+	DeviceSecretGrace   time.Duration // 轮换后旧设备密钥的宽限期（DEVICE_SECRET_GRACE）
+	FirmwareDir         string        // 固件文件存放目录（FIRMWARE_DIR）
+	DeviceRestoreWindow time.Duration // 已删除设备可恢复的时长，期满后彻底清除（DEVICE_RESTORE_WINDOW）
+
+	DeviceHistoryRetention time.Duration // 设备变更历史保留期，不随设备彻底清除而删除（DEVICE_HISTORY_RETENTION）
+
+	TelemetryPath      string        // 遥测数据文件，为空时仅保存在内存（TELEMETRY_PATH）
+	TelemetryRetention time.Duration // 遥测数据保留期（TELEMETRY_RETENTION）
+
+	AlertEvalInterval time.Duration // 告警规则求值间隔（ALERT_EVAL_INTERVAL）
+	AlertRetention    time.Duration // 已恢复告警的保留期（ALERT_RETENTION）
+
+	QRTicketTTL time.Duration // 二维码登录票据有效期（QR_TICKET_TTL）
+	CaptchaTTL  time.Duration // 图形验证码有效期（CAPTCHA_TTL）
+
+	MailDriver string // 发件实现：outbox | smtp（MAIL_DRIVER）
+	MailOutbox string // outbox 文件路径，为空时写日志（MAIL_OUTBOX）
+	MailFrom   string // 发件人，默认同 SMTP_USER（MAIL_FROM）
+	SMTPHost   string // SMTP_HOST，如 smtp.163.com
+	SMTPPort   int    // SMTP_PORT，465 为 SSL
+	SMTPUser   string // SMTP_USER
+	SMTPPass   string // SMTP_PASS
+}
+
+var cfg = loadConfig()
+
+func loadConfig() Config {
+	return Config{
+		RunWorkers:    envInt("WORKFLOW_RUN_WORKERS", 4),
+		RunMaxWorkers: envInt("WORKFLOW_RUN_MAX_WORKERS", 32),
+		StoreDriver:   envString("STORE_DRIVER", "memory"),
+		StorePath:     envString("STORE_PATH", "data/collabweb.json"),
+
+		StoreFlushInterval: envDuration("STORE_FLUSH_INTERVAL", 200*time.Millisecond),
+
+		SessionTTL:  envDuration("SESSION_TTL", 24*time.Hour),
+		RememberTTL: envDuration("SESSION_REMEMBER_TTL", 30*24*time.Hour),
+
+		AdminAccount: normalizeAccount(envString("ADMIN_ACCOUNT", "")),
+
+		LoginMaxFailures:  envInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockout:      envDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginCaptchaAfter: envInt("LOGIN_CAPTCHA_AFTER", 3),
+
+		LoginFailureWindow: envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+
+		CodeTTL:            envDuration("CODE_TTL", 5*time.Minute),
+		CodeResendCooldown: envDuration("CODE_RESEND_COOLDOWN", time.Minute),
+		CodeMaxAttempts:    envInt("CODE_MAX_ATTEMPTS", 5),
+
+		DeviceOnlineTimeout: envDuration("DEVICE_ONLINE_TIMEOUT", 2*time.Minute),
+		DeviceSweepInterval: envDuration("DEVICE_SWEEP_INTERVAL", 15*time.Second),
+		CommandTTL:          envDuration("COMMAND_TTL", 10*time.Minute),
+		DeviceEnrollmentTTL: envDuration("DEVICE_ENROLLMENT_TTL", 72*time.Hour),
+		DeviceSecretGrace:   envDuration("DEVICE_SECRET_GRACE", 10*time.Minute),
+		FirmwareDir:         envString("FIRMWARE_DIR", "data/firmware"),
+		DeviceRestoreWindow: envDuration("DEVICE_RESTORE_WINDOW", 7*24*time.Hour),
+
+		DeviceHistoryRetention: envDuration("DEVICE_HISTORY_RETENTION", 365*24*time.Hour),
+
+		TelemetryPath:      envString("TELEMETRY_PATH", ""),
+		TelemetryRetention: envDuration("TELEMETRY_RETENTION", 7*24*time.Hour),
+
+		AlertEvalInterval: envDuration("ALERT_EVAL_INTERVAL", 15*time.Second),
+		AlertRetention:    envDuration("ALERT_RETENTION", 30*24*time.Hour),
+
+		QRTicketTTL: envDuration("QR_TICKET_TTL", 5*time.Minute),
+		CaptchaTTL:  envDuration("CAPTCHA_TTL", 5*time.Minute),
+
+		MailDriver: envString("MAIL_DRIVER", "outbox"),
+		MailOutbox: envString("MAIL_OUTBOX", ""),
+		MailFrom:   envString("MAIL_FROM", ""),
+		SMTPHost:   envString("SMTP_HOST", ""),
+		SMTPPort:   envInt("SMTP_PORT", 465),
+		SMTPUser:   envString("SMTP_USER", ""),
+		SMTPPass:   envString("SMTP_PASS", ""),
+	}
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// 支持 Go duration 格式，如 "30m"、"12h"
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxDeviceTags   = 32
+	maxDeviceTagLen = 64 // 字符数
+)
+
+// 设备列表过滤条件，均为可选；多个条件同时满足才匹配
+type deviceFilter struct {
+	types            map[string]bool // type=Camera,Sensor（不区分大小写）
+	online           *bool           // online=true|false
+	lastOnlineBefore int64           // last_online_before=<unix 秒>
+	lastOnlineAfter  int64           // last_online_after=<unix 秒>
+	createdAfter     int64           // created_after=<unix 秒>
+	namePrefix       string          // name=仓库*（末尾 * 为前缀匹配）
+	nameContains     string          // name=仓库（子串匹配）
+	query            string          // q=...，在名称、ID、类型与标签中做子串匹配
+	tags             []string        // tag=a&tag=b 或 tags=a,b，需全部包含
+	labels           labelSelector   // labels=site=sh,floor=3，语法见 labels.go
+}
+
+// 解析查询参数；参数格式错误时返回的错误信息可直接返回给调用方
+func parseDeviceFilter(q url.Values) (deviceFilter, error) {
+	var f deviceFilter
+
+	for _, t := range splitList(q["type"]) {
+		if f.types == nil {
+			f.types = map[string]bool{}
+		}
+		f.types[strings.ToLower(t)] = true
+	}
+
+	if v := strings.TrimSpace(q.Get("online")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("Invalid online: must be true or false")
+		}
+		f.online = &b
+	}
+
+	for _, tp := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"last_online_before", &f.lastOnlineBefore},
+		{"last_online_after", &f.lastOnlineAfter},
+		{"created_after", &f.createdAfter},
+	} {
+		v := strings.TrimSpace(q.Get(tp.name))
+		if v == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ts < 0 {
+			return f, errors.New("Invalid " + tp.name + ": must be a unix timestamp in seconds")
+		}
+		*tp.dst = ts
+	}
+
+	if name := strings.TrimSpace(q.Get("name")); name != "" {
+		if strings.HasSuffix(name, "*") {
+			f.namePrefix = foldText(strings.TrimRight(name, "*"))
+		} else {
+			f.nameContains = foldText(name)
+		}
+	}
+	f.query = foldText(strings.TrimSpace(q.Get("q")))
+
+	f.tags = append(splitList(q["tag"]), splitList(q["tags"])...)
+	for i, t := range f.tags {
+		f.tags[i] = foldText(t)
+	}
+
+	for _, v := range q["labels"] {
+		sel, err := parseLabelSelector(v)
+		if err != nil {
+			return f, err
+		}
+		f.labels = append(f.labels, sel...)
+	}
+	return f, nil
+}
+
+// 逗号分隔或重复出现的参数展开为列表，忽略空值
+func splitList(values []string) []string {
+	list := make([]string, 0)
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// 大小写折叠，按 Unicode 字符处理，中文名称原样参与匹配
+func foldText(s string) string {
+	return strings.ToLower(s)
+}
+
+func (f deviceFilter) match(d DeviceResponse) bool {
+	if f.types != nil && !f.types[strings.ToLower(d.Type)] {
+		return false
+	}
+	if f.online != nil && d.Online != *f.online {
+		return false
+	}
+	if f.lastOnlineBefore != 0 && d.LastOnline >= f.lastOnlineBefore {
+		return false
+	}
+	if f.lastOnlineAfter != 0 && d.LastOnline <= f.lastOnlineAfter {
+		return false
+	}
+	if f.createdAfter != 0 && d.CreatedAt <= f.createdAfter {
+		return false
+	}
+
+	name := foldText(d.Name)
+	if f.namePrefix != "" && !strings.HasPrefix(name, f.namePrefix) {
+		return false
+	}
+	if f.nameContains != "" && !strings.Contains(name, f.nameContains) {
+		return false
+	}
+
+	tags := map[string]bool{}
+	for _, t := range d.Tags {
+		tags[foldText(t)] = true
+	}
+	for _, t := range f.tags {
+		if !tags[t] {
+			return false
+		}
+	}
+
+	if !f.labels.matches(d.Labels) {
+		return false
+	}
+
+	if f.query != "" {
+		hit := strings.Contains(name, f.query) ||
+			strings.Contains(foldText(d.ID), f.query) ||
+			strings.Contains(foldText(d.Type), f.query)
+		for t := range tags {
+			hit = hit || strings.Contains(t, f.query)
+		}
+		if !hit {
+			return false
+		}
+	}
+	return true
+}
+
+// 规范化标签：去除首尾空白、去重（不区分大小写）并保留首次出现的写法
+func normalizeTags(in []string) ([]string, error) {
+	out := make([]string, 0, len(in))
+	seen := map[string]bool{}
+	for _, t := range in {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if len([]rune(t)) > maxDeviceTagLen || strings.Contains(t, ",") {
+			return nil, errors.New("Invalid tag: " + t)
+		}
+		if k := foldText(t); !seen[k] {
+			seen[k] = true
+			out = append(out, t)
+		}
+	}
+	if len(out) > maxDeviceTags {
+		return nil, errors.New("Too many tags")
+	}
+	return out, nil
+}
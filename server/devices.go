@@ -2,59 +2,68 @@ package main
 
 import (
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
     "strconv"
     "strings"
     "sort"
-    "sync"
     "time"
 )
 
 type Device struct {
-	ID         string `json:"id"` // d开头的12字节字符串
-	Name       string `json:"name"`
-	Type       string `json:"type"`
-	LastOnline int64  `json:"lastOnline"` // 最近在线时间戳
-	CreatedAt  int64  `json:"createdAt"`  // 创建时间戳
-	UpdatedAt  int64  `json:"updatedAt"`  // 更新时间戳
+	ID              string            `json:"id"` // d开头的12字节字符串
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	LastOnline      int64             `json:"lastOnline"`                // 最近在线时间戳
+	CreatedAt       int64             `json:"createdAt"`                 // 创建时间戳
+	UpdatedAt       int64             `json:"updatedAt"`                 // 更新时间戳
+	OwnerID         string            `json:"ownerId,omitempty"`         // 创建者用户 ID，种子数据为空
+	WorkspaceID     string            `json:"workspaceId"`               // 所属工作区
+	Tags            []string          `json:"tags,omitempty"`            // 自由标签，用于列表过滤
+	Labels          map[string]string `json:"labels,omitempty"`          // key=value 标签，可用 labels 选择器过滤
+	Attributes      json.RawMessage   `json:"attributes,omitempty"`      // 自定义属性（JSON 对象），如位置、固件版本
+	FirmwareVersion string            `json:"firmwareVersion,omitempty"` // 最近一次 OTA 升级成功的固件版本
+}
+
+// 设备响应：附带派生的在线状态，不写入存储
+type DeviceResponse struct {
+	Device
+	Online bool `json:"online"` // 按 LastOnline 与 DEVICE_ONLINE_TIMEOUT 计算
 }
 
 type CreateDeviceRequest struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Tags       []string          `json:"tags"`
+	Labels     map[string]string `json:"labels"`
+	Attributes json.RawMessage   `json:"attributes"`
 }
 
-type UpdateDeviceRequest struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+// 创建响应附带一次性注册令牌，设备凭此调用 POST /api/v1/device-enrollments 换取密钥
+type CreateDeviceResponse struct {
+	DeviceResponse
+	EnrollmentToken     string `json:"enrollmentToken"`
+	EnrollmentExpiresAt int64  `json:"enrollmentExpiresAt"`
 }
 
-// 内存存储设备数据
-var (
-	devicesMu    sync.RWMutex
-	devicesStore = make(map[string]Device)
-	deviceSeq    = 0
-)
+// PUT 语义：提供的字段整体替换，省略的字段保持不变（tags/labels 传 [] 或 {} 清空）
+type UpdateDeviceRequest struct {
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Tags       *[]string          `json:"tags"`
+	Labels     *map[string]string `json:"labels"`
+	Attributes json.RawMessage    `json:"attributes"`
+}
 
-func init() {
-	// 初始化一些示例设备
-	types := []string{"Sensor", "Actuator", "Gateway", "Camera"}
-	now := time.Now().Unix()
-	for i := 0; i < 50; i++ {
-		deviceSeq++
-		id := fmt.Sprintf("d%012d", deviceSeq)
-		device := Device{
-			ID:         id,
-			Name:       fmt.Sprintf("设备%03d", i+1),
-			Type:       types[i%len(types)],
-			LastOnline: now - int64(i*60),
-			CreatedAt:  now - int64(i*3600),
-			UpdatedAt:  now - int64(i*60),
-		}
-		devicesStore[id] = device
-	}
+// PATCH 语义：labels 按键合并（值为 null 删除该键），attributes 按 JSON Merge Patch（RFC 7396）合并
+type PatchDeviceRequest struct {
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Tags       *[]string          `json:"tags"`
+	Labels     map[string]*string `json:"labels"`
+	Attributes json.RawMessage    `json:"attributes"`
 }
 
 // GET /api/v1/devices (list), POST /api/v1/devices (create)
@@ -69,7 +78,12 @@ func devicesCollectionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /api/v1/devices/{id}, PUT /api/v1/devices/{id}, DELETE /api/v1/devices/{id}
+// GET /api/v1/devices/{id}, PUT/PATCH /api/v1/devices/{id}, DELETE /api/v1/devices/{id}
+// POST /api/v1/devices/{id}/heartbeats, GET/POST /api/v1/devices/{id}/telemetry
+// /api/v1/devices/{id}/commands[/...] (see commands.go), /api/v1/devices/{id}/credentials[/...] (see provisioning.go)
+// POST /api/v1/devices/{id}/ota, GET /api/v1/devices/{id}/firmware/{fwId} (see ota.go)
+// /api/v1/devices/{id}/twin[/...] (see twin.go)
+// GET /api/v1/devices/{id}/history, POST /api/v1/devices/{id}/restore (see history.go)
 func deviceResourceHandler(w http.ResponseWriter, r *http.Request) {
 	// 提取设备 ID
 	path := r.URL.Path
@@ -78,17 +92,47 @@ func deviceResourceHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
 		return
 	}
-	id := strings.TrimPrefix(path, prefix)
-	if id == "" || strings.Contains(id, "/") {
+	parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	id := parts[0]
+	if id == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid device ID"})
 		return
 	}
 
+	// 子资源：/api/v1/devices/{id}/heartbeats|telemetry|commands|credentials|ota|firmware|twin|history|restore
+	if len(parts) > 1 {
+		switch {
+		case len(parts) == 2 && parts[1] == "heartbeats":
+			deviceHeartbeatsHandler(w, r, id)
+		case len(parts) == 2 && parts[1] == "telemetry":
+			deviceTelemetryHandler(w, r, id)
+		case parts[1] == "commands" && len(parts) <= 4:
+			deviceCommandsHandler(w, r, id, parts[2:])
+		case parts[1] == "credentials" && len(parts) <= 3:
+			deviceCredentialsHandler(w, r, id, parts[2:])
+		case len(parts) == 2 && parts[1] == "ota":
+			deviceOTAReportHandler(w, r, id)
+		case len(parts) == 3 && parts[1] == "firmware":
+			deviceFirmwareDownloadHandler(w, r, id, parts[2])
+		case parts[1] == "twin" && len(parts) <= 3:
+			deviceTwinHandler(w, r, id, parts[2:])
+		case len(parts) == 2 && parts[1] == "history":
+			deviceHistoryHandler(w, r, id)
+		case len(parts) == 2 && parts[1] == "restore":
+			deviceRestoreHandler(w, r, id)
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		getDevice(w, r, id)
 	case http.MethodPut:
 		updateDevice(w, r, id)
+	case http.MethodPatch:
+		patchDevice(w, r, id)
 	case http.MethodDelete:
 		deleteDevice(w, r, id)
 	default:
@@ -97,19 +141,29 @@ func deviceResourceHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getDevicesList(w http.ResponseWriter, r *http.Request) {
-    devicesMu.RLock()
-    defer devicesMu.RUnlock()
+    q := r.URL.Query()
+    filter, err := parseDeviceFilter(q)
+    if err != nil {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        return
+    }
 
-    // 转换为切片
-    devices := make([]Device, 0, len(devicesStore))
-    for _, device := range devicesStore {
-        devices = append(devices, device)
+    // 仅列出调用方当前工作区的设备，total 为过滤后的数量
+    p, _ := currentPrincipal(r)
+    now := time.Now()
+    devices := make([]DeviceResponse, 0)
+    for _, d := range store.ListDevices() {
+        if d.WorkspaceID != p.WorkspaceID {
+            continue
+        }
+        if dr := withOnline(d, now); filter.match(dr) {
+            devices = append(devices, dr)
+        }
     }
 
     // 读取排序与分页参数（REST 风格：下划线命名）
     page := 1
     pageSize := 20
-    q := r.URL.Query()
     sortBy := strings.ToLower(strings.TrimSpace(q.Get("sort_by")))
     order := strings.ToLower(strings.TrimSpace(q.Get("order")))
     if sortBy == "" { sortBy = "lastonline" }
@@ -170,6 +224,12 @@ func getDevicesList(w http.ResponseWriter, r *http.Request) {
 }
 
 func createDevice(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
@@ -182,48 +242,99 @@ func createDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 验证必填字段
-	if strings.TrimSpace(req.Name) == "" {
-		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Device name is required"})
+	device, err := newDevice(p, req)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
 		return
 	}
-	if strings.TrimSpace(req.Type) == "" {
-		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Device type is required"})
-		return
+
+	created := createDevices(p, []Device{device})
+	writeJSON(w, http.StatusCreated, CreateDeviceResponse{
+		DeviceResponse:      withOnline(device, time.Now()),
+		EnrollmentToken:     created[0].token,
+		EnrollmentExpiresAt: created[0].cred.EnrollmentExpiresAt,
+	})
+}
+
+type createdDevice struct {
+	token string
+	cred  DeviceCredential
+}
+
+// 保存新设备并签发注册令牌、记录历史，一次写入存储；结果与 ds 一一对应
+func createDevices(p Principal, ds []Device) []createdDevice {
+	out := make([]createdDevice, len(ds))
+	creds := make([]DeviceCredential, len(ds))
+	var h historyBatch
+	for i, d := range ds {
+		out[i].token, out[i].cred = newEnrollment(d.ID)
+		creds[i] = out[i].cred
+		h.add(p, historyCreate, d, deviceChanges(Device{}, d))
 	}
+	store.CreateDevices(ds, creds, h.entries())
+	return out
+}
 
-	devicesMu.Lock()
-	defer devicesMu.Unlock()
+// 校验创建请求并分配设备 ID
+func newDevice(p Principal, req CreateDeviceRequest) (Device, error) {
+	d, err := buildDevice(p, req)
+	if err != nil {
+		return Device{}, err
+	}
+	d.ID = deviceID(store.NextSeq("device"))
+	return d, nil
+}
 
-	// 生成新设备 ID
-	deviceSeq++
-	id := fmt.Sprintf("d%012d", deviceSeq)
-	now := time.Now().Unix()
+func deviceID(seq int) string {
+	return fmt.Sprintf("d%012d", seq)
+}
 
-	device := Device{
-		ID:         id,
-		Name:       strings.TrimSpace(req.Name),
-		Type:       strings.TrimSpace(req.Type),
-		LastOnline: now,
-		CreatedAt:  now,
-		UpdatedAt:  now,
+// 校验创建请求，返回尚未分配 ID 的设备；单个创建与批量创建共用
+func buildDevice(p Principal, req CreateDeviceRequest) (Device, error) {
+	// 验证必填字段
+	if strings.TrimSpace(req.Name) == "" {
+		return Device{}, errors.New("Device name is required")
+	}
+	if strings.TrimSpace(req.Type) == "" {
+		return Device{}, errors.New("Device type is required")
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return Device{}, err
+	}
+	labels, err := normalizeLabels(req.Labels)
+	if err != nil {
+		return Device{}, err
+	}
+	attrs, err := normalizeAttributes(req.Attributes)
+	if err != nil {
+		return Device{}, err
 	}
 
-	devicesStore[id] = device
-	writeJSON(w, http.StatusCreated, device)
+	now := time.Now().Unix()
+	return Device{
+		Name:        strings.TrimSpace(req.Name),
+		Type:        strings.TrimSpace(req.Type),
+		LastOnline:  now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		OwnerID:     p.UserID,
+		WorkspaceID: p.WorkspaceID,
+		Tags:        tags,
+		Labels:      labels,
+		Attributes:  attrs,
+	}, nil
 }
 
 func getDevice(w http.ResponseWriter, r *http.Request, id string) {
-	devicesMu.RLock()
-	defer devicesMu.RUnlock()
-
-	device, exists := devicesStore[id]
+	p, _ := currentPrincipal(r)
+	device, exists := workspaceDevice(p, id)
 	if !exists {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, device)
+	writeJSON(w, http.StatusOK, withOnline(device, time.Now()))
 }
 
 func updateDevice(w http.ResponseWriter, r *http.Request, id string) {
@@ -238,39 +349,187 @@ func updateDevice(w http.ResponseWriter, r *http.Request, id string) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
 		return
 	}
+	var tags []string
+	if req.Tags != nil {
+		if tags, err = normalizeTags(*req.Tags); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	var labels map[string]string
+	if req.Labels != nil {
+		if labels, err = normalizeLabels(*req.Labels); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	var attrs json.RawMessage
+	if req.Attributes != nil {
+		if attrs, err = normalizeAttributes(req.Attributes); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	}
 
-	devicesMu.Lock()
-	defer devicesMu.Unlock()
-
-	device, exists := devicesStore[id]
-	if !exists {
+	p, _ := currentPrincipal(r)
+	var before Device
+	device, err := store.UpdateDevice(id, func(d *Device) error {
+		if d.WorkspaceID != p.WorkspaceID {
+			return errNotFound
+		}
+		if !canModify(p, d.OwnerID) {
+			return errForbidden
+		}
+		before = *d
+		// 更新字段
+		if strings.TrimSpace(req.Name) != "" {
+			d.Name = strings.TrimSpace(req.Name)
+		}
+		if strings.TrimSpace(req.Type) != "" {
+			d.Type = strings.TrimSpace(req.Type)
+		}
+		if req.Tags != nil {
+			d.Tags = tags
+		}
+		if req.Labels != nil {
+			d.Labels = labels
+		}
+		if req.Attributes != nil {
+			d.Attributes = attrs
+		}
+		d.UpdatedAt = time.Now().Unix()
+		return nil
+	})
+	if err == errForbidden {
+		writeForbidden(w)
+		return
+	}
+	if err != nil {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
 		return
 	}
+	recordDeviceHistory(p, historyUpdate, device, deviceChanges(before, device))
+
+	writeJSON(w, http.StatusOK, withOnline(device, time.Now()))
+}
+
+func patchDevice(w http.ResponseWriter, r *http.Request, id string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	var req PatchDeviceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	var tags []string
+	if req.Tags != nil {
+		if tags, err = normalizeTags(*req.Tags); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	lp, err := parseLabelPatch(req.Labels)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
 
-	// 更新字段
-	if strings.TrimSpace(req.Name) != "" {
-		device.Name = strings.TrimSpace(req.Name)
+	p, _ := currentPrincipal(r)
+	var before Device
+	device, err := store.UpdateDevice(id, func(d *Device) error {
+		if d.WorkspaceID != p.WorkspaceID {
+			return errNotFound
+		}
+		if !canModify(p, d.OwnerID) {
+			return errForbidden
+		}
+		before = *d
+		if req.Attributes != nil {
+			attrs, err := mergeAttributes(d.Attributes, req.Attributes)
+			if err != nil {
+				return err
+			}
+			d.Attributes = attrs
+		}
+		if !lp.empty() {
+			labels, err := lp.apply(d.Labels)
+			if err != nil {
+				return err
+			}
+			d.Labels = labels
+		}
+		if strings.TrimSpace(req.Name) != "" {
+			d.Name = strings.TrimSpace(req.Name)
+		}
+		if strings.TrimSpace(req.Type) != "" {
+			d.Type = strings.TrimSpace(req.Type)
+		}
+		if req.Tags != nil {
+			d.Tags = tags
+		}
+		d.UpdatedAt = time.Now().Unix()
+		return nil
+	})
+	if err == errForbidden {
+		writeForbidden(w)
+		return
 	}
-	if strings.TrimSpace(req.Type) != "" {
-		device.Type = strings.TrimSpace(req.Type)
+	if err == errNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
 	}
-	device.UpdatedAt = time.Now().Unix()
+	recordDeviceHistory(p, historyUpdate, device, deviceChanges(before, device))
 
-	devicesStore[id] = device
-	writeJSON(w, http.StatusOK, device)
+	writeJSON(w, http.StatusOK, withOnline(device, time.Now()))
 }
 
 func deleteDevice(w http.ResponseWriter, r *http.Request, id string) {
-	devicesMu.Lock()
-	defer devicesMu.Unlock()
-
-	_, exists := devicesStore[id]
-	if !exists {
+	p, _ := currentPrincipal(r)
+	d, ok := workspaceDevice(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	if !canModify(p, d.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	if !removeDevice(p, id) {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
 		return
 	}
 
-	delete(devicesStore, id)
 	writeJSON(w, http.StatusNoContent, nil)
 }
+
+// 删除设备：移入回收站并丢弃未完成的指令；遥测、凭据与孪生保留到恢复期满（见 history.go）
+func removeDevice(p Principal, id string) bool {
+	return removeDevices(p, []string{id})[0]
+}
+
+// 批量删除，一次写入存储；结果与 ids 一一对应，false 表示设备不存在
+func removeDevices(p Principal, ids []string) []bool {
+	trashed := store.TrashDevices(ids, p.UserID, time.Now().Unix())
+	ok := make([]bool, len(ids))
+	var h historyBatch
+	for i, d := range trashed {
+		if d == nil {
+			continue
+		}
+		ok[i] = true
+		dropDeviceCommands(d.ID)
+		h.add(p, historyDelete, *d, nil)
+	}
+	if entries := h.entries(); len(entries) > 0 {
+		store.AppendDeviceHistory(entries...)
+	}
+	return ok
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 服务端事件，如设备上下线；通过进程内总线分发给 SSE 订阅者
+type Event struct {
+	Type        string                 `json:"type"` // 如 device.online、device.offline
+	DeviceID    string                 `json:"deviceId,omitempty"`
+	WorkspaceID string                 `json:"workspaceId,omitempty"`
+	At          int64                  `json:"at"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// ---- In-memory event bus ----
+var (
+	eventsMu    sync.Mutex
+	subscribers = map[chan Event]struct{}{}
+)
+
+// 发布事件；订阅者缓冲区满时丢弃，避免慢消费者阻塞发布方
+func publishEvent(e Event) {
+	if e.At == 0 {
+		e.At = time.Now().Unix()
+	}
+	log.Printf("event: %s device=%s workspace=%s", e.Type, e.DeviceID, e.WorkspaceID)
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func subscribeEvents() (chan Event, func()) {
+	ch := make(chan Event, 64)
+	eventsMu.Lock()
+	subscribers[ch] = struct{}{}
+	eventsMu.Unlock()
+	return ch, func() {
+		eventsMu.Lock()
+		delete(subscribers, ch)
+		eventsMu.Unlock()
+	}
+}
+
+// GET /api/v1/events/stream - SSE stream of events in the caller's workspace
+func eventsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("streaming unsupported"))
+		return
+	}
+
+	ch, cancel := subscribeEvents()
+	defer cancel()
+
+	_, _ = fmt.Fprintf(w, ": connected\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	ctx := r.Context()
+	for {
+		select {
+		case e := <-ch:
+			if e.WorkspaceID != p.WorkspaceID {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			_, _ = fmt.Fprintf(w, "event: %s\n", e.Type)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-ticker.C:
+			_, _ = fmt.Fprintf(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
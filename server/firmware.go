@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 单个固件包的大小上限
+const maxFirmwareSize = 256 << 20
+
+// 固件包元数据；文件内容保存在 FIRMWARE_DIR/<id>.bin
+type Firmware struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	DeviceType  string `json:"deviceType"` // 适用的设备类型，如 Gateway、Camera
+	Version     string `json:"version"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Notes       string `json:"notes,omitempty"`
+	UploadedBy  string `json:"uploadedBy"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+func firmwarePath(id string) string {
+	return filepath.Join(cfg.FirmwareDir, id+".bin")
+}
+
+// GET /api/v1/firmware (list), POST /api/v1/firmware (multipart upload)
+func firmwareCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listFirmware(w, r)
+	case http.MethodPost:
+		uploadFirmware(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET/DELETE /api/v1/firmware/{id}, GET /api/v1/firmware/{id}/download
+func firmwareResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/firmware/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || len(parts) == 2 && parts[1] != "download" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	fw, ok := store.GetFirmware(id)
+	if !ok || fw.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Firmware not found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		serveFirmware(w, r, fw)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, fw)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		deleteFirmware(w, r, fw)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// ?device_type= 过滤，最新的在前
+func listFirmware(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	typ := strings.TrimSpace(r.URL.Query().Get("device_type"))
+	list := make([]Firmware, 0)
+	for _, fw := range store.ListFirmware() {
+		if fw.WorkspaceID != p.WorkspaceID {
+			continue
+		}
+		if typ == "" || strings.EqualFold(fw.DeviceType, typ) {
+			list = append(list, fw)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"firmware": list, "total": len(list)})
+}
+
+// multipart 字段：file（固件文件）、version、deviceType、sha256（可选，提供时校验）、notes
+func uploadFirmware(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFirmwareSize+1<<20)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid multipart body"})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	version := strings.TrimSpace(r.FormValue("version"))
+	deviceType := strings.TrimSpace(r.FormValue("deviceType"))
+	want := strings.ToLower(strings.TrimSpace(r.FormValue("sha256")))
+	if version == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Firmware version is required"})
+		return
+	}
+	if deviceType == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Device type is required"})
+		return
+	}
+	for _, fw := range store.ListFirmware() {
+		if fw.WorkspaceID == p.WorkspaceID && strings.EqualFold(fw.DeviceType, deviceType) && fw.Version == version {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Firmware version already exists for this device type"})
+			return
+		}
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Firmware file is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxFirmwareSize {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "Firmware file is too large"})
+		return
+	}
+
+	id := fmt.Sprintf("fw-%d", store.NextSeq("firmware"))
+	if err := os.MkdirAll(cfg.FirmwareDir, 0o755); err != nil {
+		log.Printf("firmware: mkdir %s: %v", cfg.FirmwareDir, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to store firmware"})
+		return
+	}
+	// 先写临时文件，校验通过后再改名，避免留下不完整的文件
+	tmp, err := os.CreateTemp(cfg.FirmwareDir, id+"-*.tmp")
+	if err != nil {
+		log.Printf("firmware: create temp file: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to store firmware"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	sum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, sum), file)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Printf("firmware: write %s: %v", tmp.Name(), err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to store firmware"})
+		return
+	}
+	got := hex.EncodeToString(sum.Sum(nil))
+	if want != "" && want != got {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Checksum mismatch: got sha256 " + got})
+		return
+	}
+	if err := os.Rename(tmp.Name(), firmwarePath(id)); err != nil {
+		log.Printf("firmware: rename %s: %v", tmp.Name(), err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to store firmware"})
+		return
+	}
+
+	fw := Firmware{
+		ID:          id,
+		WorkspaceID: p.WorkspaceID,
+		DeviceType:  deviceType,
+		Version:     version,
+		Filename:    filepath.Base(header.Filename),
+		Size:        size,
+		SHA256:      got,
+		Notes:       strings.TrimSpace(r.FormValue("notes")),
+		UploadedBy:  p.UserID,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if err := store.CreateFirmware(fw); err != nil {
+		_ = os.Remove(firmwarePath(id))
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Firmware already exists"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, fw)
+}
+
+func serveFirmware(w http.ResponseWriter, r *http.Request, fw Firmware) {
+	f, err := os.Open(firmwarePath(fw.ID))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Firmware file not found"})
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fw.Filename))
+	w.Header().Set("X-Checksum-SHA256", fw.SHA256)
+	// 支持 Range，便于设备断点续传
+	http.ServeContent(w, r, "", time.Unix(fw.CreatedAt, 0), f)
+}
+
+// 仍被进行中的升级任务引用的固件不可删除
+func deleteFirmware(w http.ResponseWriter, r *http.Request, fw Firmware) {
+	p, _ := currentPrincipal(r)
+	if !canModify(p, fw.UploadedBy) {
+		writeForbidden(w)
+		return
+	}
+	for _, c := range store.ListOTACampaigns() {
+		if c.FirmwareID == fw.ID && (c.Status == otaRunning || c.Status == otaPaused) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Firmware is used by campaign " + c.ID})
+			return
+		}
+	}
+	if !store.DeleteFirmware(fw.ID) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Firmware not found"})
+		return
+	}
+	if err := os.Remove(firmwarePath(fw.ID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("firmware: remove %s: %v", fw.ID, err)
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
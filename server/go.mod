@@ -1,3 +1,7 @@
 module collabweb
 
 go 1.20
+
+require golang.org/x/crypto v0.33.0
+
+require github.com/dchest/captcha v1.1.0
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 设备分组：静态分组显式列出成员，动态分组按标签选择器实时匹配
+const (
+	groupStatic  = "static"
+	groupDynamic = "dynamic"
+
+	maxGroupDevices = 10000
+)
+
+type DeviceGroup struct {
+	ID          string   `json:"id"`
+	WorkspaceID string   `json:"workspaceId"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Kind        string   `json:"kind"`                // static | dynamic
+	DeviceIDs   []string `json:"deviceIds,omitempty"` // 静态分组成员
+	Selector    string   `json:"selector,omitempty"`  // 动态分组的标签选择器，如 site=sh,floor=3
+	OwnerID     string   `json:"ownerId"`
+	CreatedAt   int64    `json:"createdAt"`
+	UpdatedAt   int64    `json:"updatedAt"`
+}
+
+type DeviceGroupResponse struct {
+	DeviceGroup
+	DeviceCount int `json:"deviceCount"` // 当前解析出的成员数
+}
+
+type CreateDeviceGroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Kind        string   `json:"kind"`
+	DeviceIDs   []string `json:"deviceIds"`
+	Selector    string   `json:"selector"`
+}
+
+// 省略的字段保持不变；分组类型不可修改
+type UpdateDeviceGroupRequest struct {
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	DeviceIDs   *[]string `json:"deviceIds"`
+	Selector    *string   `json:"selector"`
+}
+
+// 解析分组当前成员（仅限分组所在工作区），d 已填充在线状态
+func groupDevices(g DeviceGroup) []DeviceResponse {
+	now := time.Now()
+	list := make([]DeviceResponse, 0)
+	if g.Kind == groupDynamic {
+		sel, _ := parseLabelSelector(g.Selector)
+		for _, d := range store.ListDevices() {
+			if d.WorkspaceID == g.WorkspaceID && sel.matches(d.Labels) {
+				list = append(list, withOnline(d, now))
+			}
+		}
+	} else {
+		// 已删除的设备自动忽略
+		for _, id := range g.DeviceIDs {
+			if d, ok := store.GetDevice(id); ok && d.WorkspaceID == g.WorkspaceID {
+				list = append(list, withOnline(d, now))
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func deviceGroupResponse(g DeviceGroup) DeviceGroupResponse {
+	return DeviceGroupResponse{DeviceGroup: g, DeviceCount: len(groupDevices(g))}
+}
+
+// 规范化静态分组成员：去重，并要求设备属于调用方当前工作区
+func normalizeGroupDevices(p Principal, ids []string) ([]string, string) {
+	out := make([]string, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if _, ok := workspaceDevice(p, id); !ok {
+			return nil, "Unknown device: " + id
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	if len(out) > maxGroupDevices {
+		return nil, fmt.Sprintf("A group can contain at most %d devices", maxGroupDevices)
+	}
+	return out, ""
+}
+
+// GET /api/v1/device-groups (list), POST /api/v1/device-groups (create)
+func deviceGroupsCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listDeviceGroups(w, r)
+	case http.MethodPost:
+		createDeviceGroup(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET/PUT/DELETE /api/v1/device-groups/{id}, GET /api/v1/device-groups/{id}/devices
+func deviceGroupResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/device-groups/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || len(parts) == 2 && parts[1] != "devices" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	g, ok := store.GetDeviceGroup(id)
+	if !ok || g.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device group not found"})
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		devices := groupDevices(g)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"devices": devices, "total": len(devices)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, deviceGroupResponse(g))
+	case http.MethodPut:
+		updateDeviceGroup(w, r, g)
+	case http.MethodDelete:
+		deleteDeviceGroup(w, r, g)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func listDeviceGroups(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	list := make([]DeviceGroupResponse, 0)
+	for _, g := range store.ListDeviceGroups() {
+		if g.WorkspaceID == p.WorkspaceID {
+			list = append(list, deviceGroupResponse(g))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"groups": list, "total": len(list)})
+}
+
+func createDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req CreateDeviceGroupRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Group name is required"})
+		return
+	}
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if kind == "" {
+		kind = groupStatic
+	}
+	now := time.Now().Unix()
+	g := DeviceGroup{
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		Kind:        kind,
+		WorkspaceID: p.WorkspaceID,
+		OwnerID:     p.UserID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	switch kind {
+	case groupStatic:
+		ids, msg := normalizeGroupDevices(p, req.DeviceIDs)
+		if msg != "" {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+			return
+		}
+		g.DeviceIDs = ids
+	case groupDynamic:
+		sel, err := parseLabelSelector(req.Selector)
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(sel) == 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Dynamic groups require a selector"})
+			return
+		}
+		g.Selector = sel.String()
+	default:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Kind must be static or dynamic"})
+		return
+	}
+
+	g.ID = fmt.Sprintf("grp-%d", store.NextSeq("group"))
+	if err := store.CreateDeviceGroup(g); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Device group already exists"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, deviceGroupResponse(g))
+}
+
+func updateDeviceGroup(w http.ResponseWriter, r *http.Request, g DeviceGroup) {
+	p, _ := currentPrincipal(r)
+	if !canModify(p, g.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req UpdateDeviceGroupRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var ids []string
+	if req.DeviceIDs != nil {
+		if g.Kind != groupStatic {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Only static groups have deviceIds"})
+			return
+		}
+		var msg string
+		if ids, msg = normalizeGroupDevices(p, *req.DeviceIDs); msg != "" {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+			return
+		}
+	}
+	selector := ""
+	if req.Selector != nil {
+		if g.Kind != groupDynamic {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Only dynamic groups have a selector"})
+			return
+		}
+		sel, err := parseLabelSelector(*req.Selector)
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(sel) == 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Dynamic groups require a selector"})
+			return
+		}
+		selector = sel.String()
+	}
+
+	g, err = store.UpdateDeviceGroup(g.ID, func(g *DeviceGroup) error {
+		if name := strings.TrimSpace(req.Name); name != "" {
+			g.Name = name
+		}
+		if req.Description != nil {
+			g.Description = strings.TrimSpace(*req.Description)
+		}
+		if req.DeviceIDs != nil {
+			g.DeviceIDs = ids
+		}
+		if req.Selector != nil {
+			g.Selector = selector
+		}
+		g.UpdatedAt = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device group not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, deviceGroupResponse(g))
+}
+
+// 删除分组不影响其中的设备
+func deleteDeviceGroup(w http.ResponseWriter, r *http.Request, g DeviceGroup) {
+	p, _ := currentPrincipal(r)
+	if !canModify(p, g.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	if !store.DeleteDeviceGroup(g.ID) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device group not found"})
+		return
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 设备在线状态：最近一次心跳在 cfg.DeviceOnlineTimeout 内视为在线
+func deviceOnline(d Device, now time.Time) bool {
+	return now.Unix()-d.LastOnline < int64(cfg.DeviceOnlineTimeout/time.Second)
+}
+
+// 填充派生字段后返回
+func withOnline(d Device, now time.Time) DeviceResponse {
+	return DeviceResponse{Device: d, Online: deviceOnline(d, now)}
+}
+
+// ---- In-memory online state for the sweeper ----
+// 记录上一次观测到的在线状态，用于检测状态变化
+var (
+	onlineMu    sync.Mutex
+	onlineState = map[string]bool{}
+)
+
+// 记录设备状态，状态变化时发布事件；initial 为 true 时只记录不发布
+func observeOnline(d Device, online, initial bool) {
+	onlineMu.Lock()
+	prev, seen := onlineState[d.ID]
+	onlineState[d.ID] = online
+	onlineMu.Unlock()
+
+	if initial || (seen && prev == online) || (!seen && !online) {
+		return
+	}
+	typ := "device.offline"
+	if online {
+		typ = "device.online"
+	}
+	publishEvent(Event{
+		Type:        typ,
+		DeviceID:    d.ID,
+		WorkspaceID: d.WorkspaceID,
+		Data:        map[string]interface{}{"lastOnline": d.LastOnline},
+	})
+}
+
+// 后台巡检：周期性重新计算在线状态，设备超时离线时发布 device.offline
+func deviceSweeper(interval time.Duration) {
+	sweepDevices(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepDevices(false)
+	}
+}
+
+func sweepDevices(initial bool) {
+	now := time.Now()
+	live := map[string]bool{}
+	for _, d := range store.ListDevices() {
+		live[d.ID] = true
+		observeOnline(d, deviceOnline(d, now), initial)
+	}
+	// 已删除的设备不再跟踪
+	onlineMu.Lock()
+	for id := range onlineState {
+		if !live[id] {
+			delete(onlineState, id)
+		}
+	}
+	onlineMu.Unlock()
+}
+
+// POST /api/v1/devices/{id}/heartbeats (report liveness)
+func deviceHeartbeatsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodPost:
+		createHeartbeat(w, r, id)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func createHeartbeat(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := workspaceDevice(p, id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+
+	now := time.Now()
+	device, err := store.UpdateDevice(id, func(d *Device) error {
+		d.LastOnline = now.Unix()
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	// 离线设备恢复心跳时立即发布上线事件，不必等待下一次巡检
+	observeOnline(device, true, false)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         device.ID,
+		"online":     true,
+		"lastOnline": device.LastOnline,
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 设备变更历史：创建、修改、删除与恢复各追加一条记录，修改记录逐字段列出前后值。
+// 删除的设备移入回收站，DEVICE_RESTORE_WINDOW 内可恢复，期满后连同遥测、凭据与孪生一并清除；
+// 历史不随设备清除，按 DEVICE_HISTORY_RETENTION 单独过期
+const (
+	historyCreate  = "create"
+	historyUpdate  = "update"
+	historyDelete  = "delete"
+	historyRestore = "restore"
+
+	maxHistoryPageSize = 200
+)
+
+type FieldChange struct {
+	Field  string      `json:"field"` // 标签按键展开，如 labels.site
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type DeviceHistoryEntry struct {
+	ID          string        `json:"id"`
+	DeviceID    string        `json:"deviceId"`
+	WorkspaceID string        `json:"workspaceId"`
+	Action      string        `json:"action"` // create | update | delete | restore
+	Changes     []FieldChange `json:"changes,omitempty"`
+	ActorID     string        `json:"actorId"`
+	Actor       string        `json:"actor,omitempty"`    // 账号或设备名
+	APIKeyID    string        `json:"apiKeyId,omitempty"` // 通过 API Key 调用时
+	At          int64         `json:"at"`
+}
+
+type DeletedDevice struct {
+	Device
+	DeletedAt int64  `json:"deletedAt"`
+	DeletedBy string `json:"deletedBy"`
+}
+
+type DeletedDeviceResponse struct {
+	DeletedDevice
+	RestoreBefore int64 `json:"restoreBefore"`
+}
+
+// 对比两个版本的可编辑字段；before 为零值时即为创建时的全部字段
+func deviceChanges(before, after Device) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, b, a interface{}) {
+		changes = append(changes, FieldChange{Field: field, Before: b, After: a})
+	}
+	str := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	if before.Name != after.Name {
+		add("name", str(before.Name), str(after.Name))
+	}
+	if before.Type != after.Type {
+		add("type", str(before.Type), str(after.Type))
+	}
+	if !reflect.DeepEqual(before.Tags, after.Tags) && len(before.Tags)+len(after.Tags) > 0 {
+		add("tags", before.Tags, after.Tags)
+	}
+	keys := map[string]bool{}
+	for k := range before.Labels {
+		keys[k] = true
+	}
+	for k := range after.Labels {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		b, bok := before.Labels[k]
+		a, aok := after.Labels[k]
+		if bok != aok || a != b {
+			add("labels."+k, str(b), str(a))
+		}
+	}
+	if !bytes.Equal(before.Attributes, after.Attributes) {
+		var b, a interface{}
+		if len(before.Attributes) > 0 {
+			b = before.Attributes
+		}
+		if len(after.Attributes) > 0 {
+			a = after.Attributes
+		}
+		add("attributes", b, a)
+	}
+	if before.FirmwareVersion != after.FirmwareVersion {
+		add("firmwareVersion", str(before.FirmwareVersion), str(after.FirmwareVersion))
+	}
+	return changes
+}
+
+// 追加一条历史；修改记录没有实际变更时不记录
+func recordDeviceHistory(p Principal, action string, d Device, changes []FieldChange) {
+	var h historyBatch
+	h.add(p, action, d, changes)
+	if entries := h.entries(); len(entries) > 0 {
+		store.AppendDeviceHistory(entries...)
+	}
+}
+
+// 批量操作先收集历史，再一次性分配 ID 并写入
+type historyBatch []DeviceHistoryEntry
+
+func (h *historyBatch) add(p Principal, action string, d Device, changes []FieldChange) {
+	if action == historyUpdate && len(changes) == 0 {
+		return
+	}
+	*h = append(*h, DeviceHistoryEntry{
+		DeviceID:    d.ID,
+		WorkspaceID: d.WorkspaceID,
+		Action:      action,
+		Changes:     changes,
+		ActorID:     p.UserID,
+		Actor:       p.Account,
+		APIKeyID:    p.APIKeyID,
+		At:          time.Now().Unix(),
+	})
+}
+
+// 分配 ID 后返回
+func (h historyBatch) entries() []DeviceHistoryEntry {
+	if len(h) == 0 {
+		return nil
+	}
+	first := store.NextSeqRange("history", len(h))
+	for i := range h {
+		h[i].ID = fmt.Sprintf("dh-%d", first+i)
+	}
+	return h
+}
+
+// 彻底清除回收站中的设备及其关联数据
+func purgeDevice(id string) bool {
+	if !store.PurgeDeletedDevice(id) {
+		return false
+	}
+	if err := telemetry.DeleteDevice(id); err != nil {
+		log.Printf("devices: delete telemetry of %s failed: %v", id, err)
+	}
+	store.DeleteDeviceCredential(id)
+	store.DeleteDeviceTwin(id)
+	return true
+}
+
+// 定期清除超出恢复期的已删除设备与超出保留期的变更历史
+func deviceTrashJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		cutoff := now.Add(-cfg.DeviceRestoreWindow).Unix()
+		n := 0
+		for _, dd := range store.ListDeletedDevices() {
+			if dd.DeletedAt < cutoff && purgeDevice(dd.ID) {
+				n++
+			}
+		}
+		if n > 0 {
+			log.Printf("devices: purged %d deleted devices", n)
+		}
+		if n := store.PruneDeviceHistory(now.Add(-cfg.DeviceHistoryRetention).Unix()); n > 0 {
+			log.Printf("devices: pruned %d history entries", n)
+		}
+	}
+}
+
+func deletedDeviceResponse(dd DeletedDevice) DeletedDeviceResponse {
+	return DeletedDeviceResponse{
+		DeletedDevice: dd,
+		RestoreBefore: dd.DeletedAt + int64(cfg.DeviceRestoreWindow/time.Second),
+	}
+}
+
+// GET /api/v1/devices/deleted - devices in the caller's workspace that can still be restored
+func deletedDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	list := make([]DeletedDeviceResponse, 0)
+	for _, dd := range store.ListDeletedDevices() {
+		if dd.WorkspaceID == p.WorkspaceID {
+			list = append(list, deletedDeviceResponse(dd))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeletedAt > list[j].DeletedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": list, "total": len(list)})
+}
+
+// GET /api/v1/devices/{id}/history?page=&page_size= (newest first; also for deleted devices)
+func deviceHistoryHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	entries := store.ListDeviceHistory(id)
+	if _, ok := workspaceDevice(p, id); !ok {
+		// 回收站中的设备，或已彻底清除但历史仍在保留期内的设备
+		dd, trashed := store.GetDeletedDevice(id)
+		purged := !trashed && len(entries) > 0 && entries[len(entries)-1].WorkspaceID == p.WorkspaceID
+		if !purged && (!trashed || dd.WorkspaceID != p.WorkspaceID) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+			return
+		}
+	}
+
+	q := r.URL.Query()
+	page := 1
+	pageSize := 50
+	if v, err := strconv.Atoi(q.Get("page")); err == nil && v > 0 {
+		page = v
+	}
+	if v, err := strconv.Atoi(q.Get("page_size")); err == nil && v > 0 {
+		pageSize = v
+	}
+	if pageSize > maxHistoryPageSize {
+		pageSize = maxHistoryPageSize
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	// 先按总页数限制 page，避免 (page-1)*pageSize 溢出
+	start := len(entries)
+	if pages := (len(entries) + pageSize - 1) / pageSize; page <= pages {
+		start = (page - 1) * pageSize
+	}
+	end := start + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"history":   entries[start:end],
+		"total":     len(entries),
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// POST /api/v1/devices/{id}/restore - undo a delete within DEVICE_RESTORE_WINDOW
+func deviceRestoreHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	dd, ok := store.GetDeletedDevice(id)
+	if !ok || dd.WorkspaceID != p.WorkspaceID {
+		if _, live := workspaceDevice(p, id); live {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Device is not deleted"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Deleted device not found"})
+		return
+	}
+	if !canModify(p, dd.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	if time.Now().Unix() > deletedDeviceResponse(dd).RestoreBefore {
+		writeJSON(w, http.StatusGone, map[string]string{"error": "Restore window has expired"})
+		return
+	}
+
+	d, err := store.RestoreDevice(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Deleted device not found"})
+		return
+	}
+	recordDeviceHistory(p, historyRestore, d, nil)
+	writeJSON(w, http.StatusOK, withOnline(d, time.Now()))
+}
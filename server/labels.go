@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxDeviceLabels     = 64
+	maxLabelKeyLen      = 63
+	maxLabelValueLen    = 63 // 字符数
+	maxDeviceAttributes = 16 << 10
+)
+
+// 标签键：字母、数字与 . _ - /，以字母或数字开头，如 site、app.kubernetes.io/name
+func validLabelKey(k string) bool {
+	if k == "" || len(k) > maxLabelKeyLen {
+		return false
+	}
+	for i, c := range k {
+		alnum := c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c))
+		if i == 0 && !alnum {
+			return false
+		}
+		if !alnum && c != '.' && c != '_' && c != '-' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// 标签值允许中文等任意可见字符，但不能包含选择器使用的 , = ! 与空白
+func validLabelValue(v string) bool {
+	if len([]rune(v)) > maxLabelValueLen {
+		return false
+	}
+	for _, c := range v {
+		if c == ',' || c == '=' || c == '!' || unicode.IsSpace(c) || !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// 校验并去除首尾空白，空 map 返回 nil
+func normalizeLabels(in map[string]string) (map[string]string, error) {
+	if len(in) > maxDeviceLabels {
+		return nil, errors.New("Too many labels")
+	}
+	var out map[string]string
+	for k, v := range in {
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !validLabelKey(k) {
+			return nil, errors.New("Invalid label key: " + k)
+		}
+		if !validLabelValue(v) {
+			return nil, errors.New("Invalid label value for " + k)
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// 属性必须是 JSON 对象；null 或空对象视为清空
+func normalizeAttributes(raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if len(raw) > maxDeviceAttributes {
+		return nil, errors.New("Attributes must not exceed 16KB")
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return nil, errors.New("Attributes must be a JSON object")
+	}
+	if len(obj) == 0 {
+		return nil, nil
+	}
+	out, _ := json.Marshal(obj)
+	return out, nil
+}
+
+// RFC 7396 JSON Merge Patch：patch 中为 null 的键删除，对象递归合并，其余直接替换
+func mergeAttributes(current, patch json.RawMessage) (json.RawMessage, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, errors.New("Attributes must be a JSON object")
+	}
+	pm, ok := p.(map[string]interface{})
+	if !ok {
+		if p == nil {
+			return nil, nil
+		}
+		return nil, errors.New("Attributes must be a JSON object")
+	}
+	cur := map[string]interface{}{}
+	if len(current) > 0 {
+		_ = json.Unmarshal(current, &cur)
+	}
+	merged, _ := json.Marshal(mergePatch(cur, pm))
+	return normalizeAttributes(merged)
+}
+
+func mergePatch(target interface{}, patch map[string]interface{}) map[string]interface{} {
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		if pm, ok := v.(map[string]interface{}); ok {
+			t[k] = mergePatch(t[k], pm)
+			continue
+		}
+		t[k] = v
+	}
+	return t
+}
+
+// 标签选择器，逗号分隔的条件需全部满足：
+//
+//	key=value、key==value  键存在且等于 value
+//	key!=value             键不存在或不等于 value
+//	key                    键存在
+//	!key                   键不存在
+type labelSelector []labelRequirement
+
+type labelRequirement struct {
+	Key   string
+	Op    string // = | != | exists | !exists
+	Value string
+}
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = labelRequirement{Key: strings.TrimSpace(kv[0]), Op: "!=", Value: strings.TrimSpace(kv[1])}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			req = labelRequirement{Key: strings.TrimSpace(kv[0]), Op: "=", Value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			req = labelRequirement{Key: strings.TrimSpace(part[1:]), Op: "!exists"}
+		default:
+			req = labelRequirement{Key: part, Op: "exists"}
+		}
+		if !validLabelKey(req.Key) || !validLabelValue(req.Value) {
+			return nil, errors.New("Invalid label selector: " + part)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, ok := labels[req.Key]
+		switch req.Op {
+		case "=":
+			if !ok || v != req.Value {
+				return false
+			}
+		case "!=":
+			if ok && v == req.Value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 规范化后的字符串形式，用于保存动态分组的选择器
+func (sel labelSelector) String() string {
+	parts := make([]string, 0, len(sel))
+	for _, req := range sel {
+		switch req.Op {
+		case "exists":
+			parts = append(parts, req.Key)
+		case "!exists":
+			parts = append(parts, "!"+req.Key)
+		default:
+			parts = append(parts, req.Key+req.Op+req.Value)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// 标签增量修改：值为 null 的键删除，其余写入
+type labelPatch struct {
+	set map[string]string
+	del []string
+}
+
+func parseLabelPatch(in map[string]*string) (labelPatch, error) {
+	lp := labelPatch{set: map[string]string{}}
+	for k, v := range in {
+		if v != nil {
+			lp.set[k] = *v
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if !validLabelKey(k) {
+			return lp, errors.New("Invalid label key: " + k)
+		}
+		lp.del = append(lp.del, k)
+	}
+	set, err := normalizeLabels(lp.set)
+	if err != nil {
+		return lp, err
+	}
+	lp.set = set
+	return lp, nil
+}
+
+func (lp labelPatch) empty() bool {
+	return len(lp.set) == 0 && len(lp.del) == 0
+}
+
+// 返回合并后的新 map，不修改 cur
+func (lp labelPatch) apply(cur map[string]string) (map[string]string, error) {
+	labels := map[string]string{}
+	for k, v := range cur {
+		labels[k] = v
+	}
+	for _, k := range lp.del {
+		delete(labels, k)
+	}
+	for k, v := range lp.set {
+		labels[k] = v
+	}
+	if len(labels) > maxDeviceLabels {
+		return nil, errors.New("Too many labels")
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		in   string
+		want labelSelector
+		str  string
+		err  bool
+	}{
+		{in: "", want: nil, str: ""},
+		{in: "site=sh", want: labelSelector{{Key: "site", Op: "=", Value: "sh"}}, str: "site=sh"},
+		{in: "site==sh", want: labelSelector{{Key: "site", Op: "=", Value: "sh"}}, str: "site=sh"},
+		{in: "site!=sh", want: labelSelector{{Key: "site", Op: "!=", Value: "sh"}}, str: "site!=sh"},
+		{in: "site", want: labelSelector{{Key: "site", Op: "exists"}}, str: "site"},
+		{in: "!site", want: labelSelector{{Key: "site", Op: "!exists"}}, str: "!site"},
+		{in: "site=", want: labelSelector{{Key: "site", Op: "=", Value: ""}}, str: "site="},
+		{in: "楼层=三层", err: true}, // 键只允许 ASCII
+		{in: "floor=三层", want: labelSelector{{Key: "floor", Op: "=", Value: "三层"}}, str: "floor=三层"},
+		{
+			in: " tier != db , !canary,app.kubernetes.io/name==api ,",
+			want: labelSelector{
+				{Key: "tier", Op: "!=", Value: "db"},
+				{Key: "canary", Op: "!exists"},
+				{Key: "app.kubernetes.io/name", Op: "=", Value: "api"},
+			},
+			str: "!canary,app.kubernetes.io/name=api,tier!=db",
+		},
+		{in: "=sh", err: true},
+		{in: "!", err: true},
+		{in: "-site", err: true},
+		{in: "site=a=b", err: true},
+		{in: "site===sh", err: true},
+		{in: "site=s h", err: true},
+		{in: strings.Repeat("k", maxLabelKeyLen+1), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			sel, err := parseLabelSelector(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sel, tt.want) {
+				t.Errorf("selector = %+v, want %+v", sel, tt.want)
+			}
+			if got := sel.String(); got != tt.str {
+				t.Errorf("String() = %q, want %q", got, tt.str)
+			}
+		})
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"site": "sh", "tier": "edge", "canary": ""}
+	tests := []struct {
+		sel     string
+		want    bool
+		wantNil bool // 设备没有任何标签时
+	}{
+		{"", true, true},
+		{"site=sh", true, false},
+		{"site==sh", true, false},
+		{"site=bj", false, false},
+		{"zone=sh", false, false},
+		{"site!=bj", true, true},
+		{"site!=sh", false, true},
+		{"zone!=sh", true, true}, // 键不存在也满足 !=
+		{"canary", true, false},  // 值为空的键同样存在
+		{"zone", false, false},
+		{"!zone", true, true},
+		{"!canary", false, true},
+		{"site=sh,tier=edge", true, false},
+		{"site=sh,tier=core", false, false},
+		{"site=sh,!zone,tier!=core", true, false},
+	}
+	for _, tt := range tests {
+		sel, err := parseLabelSelector(tt.sel)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.sel, err)
+		}
+		if got := sel.matches(labels); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.sel, got, tt.want)
+		}
+		if got := sel.matches(nil); got != tt.wantNil {
+			t.Errorf("%q matches(nil) = %v, want %v", tt.sel, got, tt.wantNil)
+		}
+	}
+}
+
+func TestMergeAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		patch   string
+		want    string // 空串表示结果为空（已清空）
+		err     bool
+	}{
+		{name: "add key", current: `{"a":1}`, patch: `{"b":2}`, want: `{"a":1,"b":2}`},
+		{name: "replace scalar", current: `{"a":1}`, patch: `{"a":"x"}`, want: `{"a":"x"}`},
+		{name: "delete key", current: `{"a":1,"b":2}`, patch: `{"a":null}`, want: `{"b":2}`},
+		{name: "delete missing key", current: `{"a":1}`, patch: `{"z":null}`, want: `{"a":1}`},
+		{
+			name:    "nested merge and delete",
+			current: `{"loc":{"lat":1,"lng":2,"floor":{"n":3,"name":"x"}},"fw":"1.0"}`,
+			patch:   `{"loc":{"lng":null,"floor":{"name":null},"alt":9}}`,
+			want:    `{"fw":"1.0","loc":{"alt":9,"floor":{"n":3},"lat":1}}`,
+		},
+		{name: "object replaces scalar", current: `{"a":1}`, patch: `{"a":{"b":null,"c":2}}`, want: `{"a":{"c":2}}`},
+		{name: "array replaced whole", current: `{"a":[1,2,3]}`, patch: `{"a":[4]}`, want: `{"a":[4]}`},
+		{name: "delete last key clears", current: `{"a":1}`, patch: `{"a":null}`, want: ""},
+		{name: "null patch clears", current: `{"a":1}`, patch: `null`, want: ""},
+		{name: "empty current", current: "", patch: `{"a":{"b":null}}`, want: `{"a":{}}`},
+		{name: "array patch rejected", current: `{"a":1}`, patch: `[1]`, err: true},
+		{name: "scalar patch rejected", current: `{"a":1}`, patch: `"x"`, err: true},
+		{name: "invalid json rejected", current: `{"a":1}`, patch: `{`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeAttributes(json.RawMessage(tt.current), json.RawMessage(tt.patch))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("merged = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttributesSizeLimit(t *testing.T) {
+	// {"k":"xxx..."} 序列化后恰好为 n 字节
+	attrs := func(n int) string {
+		return `{"k":"` + strings.Repeat("x", n-len(`{"k":""}`)) + `"}`
+	}
+
+	if _, err := normalizeAttributes(json.RawMessage(attrs(maxDeviceAttributes))); err != nil {
+		t.Errorf("16KB attributes rejected: %v", err)
+	}
+	if _, err := normalizeAttributes(json.RawMessage(attrs(maxDeviceAttributes + 1))); err == nil {
+		t.Error("attributes over 16KB accepted")
+	}
+
+	// 每次 patch 都在限制内，但合并结果超过限制
+	current := json.RawMessage(attrs(maxDeviceAttributes - 100))
+	if _, err := mergeAttributes(current, json.RawMessage(`{"k2":"`+strings.Repeat("y", 200)+`"}`)); err == nil {
+		t.Error("merged attributes over 16KB accepted")
+	}
+	// 删除键后回到限制内
+	if _, err := mergeAttributes(current, json.RawMessage(`{"k":null,"k2":"y"}`)); err != nil {
+		t.Errorf("shrinking merge rejected: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type MailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer 发送邮件的抽象：SMTP 用于生产，outbox 用于本地开发与测试
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+// 全局发件器，main 中按配置替换
+var mailer Mailer = &outboxMailer{}
+
+func newMailer(c Config) (Mailer, error) {
+	switch c.MailDriver {
+	case "", "outbox":
+		return &outboxMailer{path: c.MailOutbox}, nil
+	case "smtp":
+		if c.SMTPHost == "" || c.SMTPPort <= 0 {
+			return nil, fmt.Errorf("smtp mailer requires SMTP_HOST and SMTP_PORT")
+		}
+		from := c.MailFrom
+		if from == "" {
+			from = c.SMTPUser
+		}
+		return &smtpMailer{host: c.SMTPHost, port: c.SMTPPort, user: c.SMTPUser, pass: c.SMTPPass, from: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", c.MailDriver)
+	}
+}
+
+// ---- SMTP ----
+
+type smtpMailer struct {
+	host string
+	port int
+	user string
+	pass string
+	from string
+}
+
+func (m *smtpMailer) Send(msg MailMessage) error {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	raw := buildMailMessage(m.from, msg)
+	var auth smtp.Auth
+	if m.user != "" {
+		auth = smtp.PlainAuth("", m.user, m.pass, m.host)
+	}
+
+	// 465 端口为隐式 TLS（SSL），其余端口由 SendMail 按需 STARTTLS
+	if m.port != 465 {
+		return smtp.SendMail(addr, auth, m.from, []string{msg.To}, raw)
+	}
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: m.host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// 组装 UTF-8 纯文本邮件，正文 base64 编码
+func buildMailMessage(from string, msg MailMessage) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	enc := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(enc) > 76 {
+		b.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc + "\r\n")
+	return b.Bytes()
+}
+
+// ---- Outbox ----
+
+// 不真正发信：追加到 JSON Lines 文件，未配置路径时写日志
+type outboxMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (m *outboxMailer) Send(msg MailMessage) error {
+	if m.path == "" {
+		log.Printf("mail outbox: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entry := struct {
+		MailMessage
+		SentAt int64 `json:"sentAt"`
+	}{msg, time.Now().Unix()}
+	return json.NewEncoder(f).Encode(entry)
+}
+
+// ---- 邮件模板 ----
+
+type mailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var mailTemplates = map[string]mailTemplate{
+	"verification_code": {
+		subject: template.Must(template.New("subject").Parse(`【验证码】您的验证码为`)),
+		body: template.Must(template.New("body").Parse(`你好，
+
+你的验证码是：{{.HiddenCode}}
+该验证码 {{.TTLMinutes}} 分钟内有效。
+
+如果不是你本人操作，请忽略本邮件。`)),
+	},
+	"workspace_invite": {
+		subject: template.Must(template.New("subject").Parse(`【邀请】{{.Inviter}} 邀请你加入工作区「{{.Workspace}}」`)),
+		body: template.Must(template.New("body").Parse(`你好，
+
+{{.Inviter}} 邀请你以 {{.Role}} 身份加入工作区「{{.Workspace}}」。
+你的邀请码是：{{.HiddenCode}}
+请使用本邮箱注册或登录后，在 {{.TTLMinutes}} 分钟内输入邀请码完成加入。
+
+如果你不认识邀请人，请忽略本邮件。`)),
+	},
+}
+
+// 按模板渲染并发送
+func sendTemplateMail(to, name string, data interface{}) error {
+	t, ok := mailTemplates[name]
+	if !ok {
+		return fmt.Errorf("unknown mail template %q", name)
+	}
+	var subject, body strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return err
+	}
+	return mailer.Send(MailMessage{To: to, Subject: subject.String(), Body: body.String()})
+}
+
+// 同形字替换 + 零宽空格，降低验证码被邮件客户端自动识别/提取的概率
+func hideCode(code string) string {
+	homoglyph := map[rune]rune{
+		'0': '⓿', // 圆圈 0
+		'1': '①',
+		'2': '②',
+		'3': '③',
+		'4': '④',
+		'5': '⑤',
+		'6': '⑥',
+		'7': '⑦',
+		'8': '⑧',
+		'9': '⑨',
+	}
+
+	var result strings.Builder
+	zw := '\u200B' // 零宽空格
+	for _, ch := range code {
+		if rep, ok := homoglyph[ch]; ok {
+			result.WriteRune(rep)
+		} else {
+			result.WriteRune(ch)
+		}
+		result.WriteRune(zw)
+	}
+	return result.String()
+}
@@ -1,27 +1,104 @@
 package main
 
 import (
+    "log"
     "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
 )
 
 func main() {
-    // API v1 - 设备资源
-    http.HandleFunc("/api/v1/devices", devicesCollectionHandler)     // GET list, POST create
-    http.HandleFunc("/api/v1/devices/", deviceResourceHandler)      // GET/PUT/DELETE by id
+    // 存储后端（STORE_DRIVER=memory|file）
+    s, err := openStore(cfg)
+    if err != nil {
+        log.Fatalf("open store: %v", err)
+    }
+    store = s
+    loadCommands()
+    go sessionJanitor(10 * time.Minute)
+    go captchaJanitor(time.Minute)
+    go deviceSweeper(cfg.DeviceSweepInterval)
+    go deviceTrashJanitor(10 * time.Minute)
 
-    // API v1 - 认证资源
+    // 遥测时序库（TELEMETRY_PATH 为空时仅内存）
+    ts, err := openTelemetryStore(cfg.TelemetryPath)
+    if err != nil {
+        log.Fatalf("open telemetry store: %v", err)
+    }
+    telemetry = ts
+    go telemetryJanitor(10 * time.Minute)
+    go alertEvaluator(cfg.AlertEvalInterval)
+    go otaWatchdog(time.Minute)
+
+    // 发件器（MAIL_DRIVER=outbox|smtp）
+    m, err := newMailer(cfg)
+    if err != nil {
+        log.Fatalf("init mailer: %v", err)
+    }
+    mailer = m
+
+    // API v1 - 设备资源（需登录或 API Key）
+    http.HandleFunc("/api/v1/devices", requireAPIAuth("devices", devicesCollectionHandler)) // GET list, POST create
+    http.HandleFunc("/api/v1/devices/", requireAPIAuth("devices", deviceResourceHandler))   // GET/PUT/PATCH/DELETE by id, POST {id}/heartbeats, GET/POST {id}/telemetry, {id}/commands, {id}/credentials, POST {id}/ota, GET {id}/firmware/{fwId}, {id}/twin, GET {id}/history, POST {id}/restore
+    http.HandleFunc("/api/v1/devices/bulk/", requireAPIAuth("devices", devicesBulkHandler)) // POST create|update|delete|commands
+    http.HandleFunc("/api/v1/devices/deleted", requireAPIAuth("devices", deletedDevicesHandler)) // GET restorable deleted devices
+    http.HandleFunc("/api/v1/device-groups", requireAPIAuth("devices", deviceGroupsCollectionHandler)) // GET list, POST create
+    http.HandleFunc("/api/v1/device-groups/", requireAPIAuth("devices", deviceGroupResourceHandler))   // GET/PUT/DELETE by id, GET {id}/devices
+    http.HandleFunc("/api/v1/firmware", requireAPIAuth("devices", firmwareCollectionHandler))          // GET list, POST multipart upload
+    http.HandleFunc("/api/v1/firmware/", requireAPIAuth("devices", firmwareResourceHandler))           // GET/DELETE by id, GET {id}/download
+    http.HandleFunc("/api/v1/ota-campaigns", requireAPIAuth("devices", otaCampaignsCollectionHandler)) // GET list, POST create and start
+    http.HandleFunc("/api/v1/ota-campaigns/", requireAPIAuth("devices", otaCampaignResourceHandler))   // GET by id, POST {id}/pause|resume|advance|cancel
+    http.HandleFunc("/api/v1/alert-rules", requireAPIAuth("devices", alertRulesCollectionHandler))     // GET list, POST create
+    http.HandleFunc("/api/v1/alert-rules/", requireAPIAuth("devices", alertRuleResourceHandler))       // GET/PUT/DELETE by id
+    http.HandleFunc("/api/v1/alerts", requireAPIAuth("devices", alertsCollectionHandler))              // GET list (?status=&severity=&rule_id=&device_id=)
+    http.HandleFunc("/api/v1/alerts/", requireAPIAuth("devices", alertResourceHandler))                // GET by id, POST/DELETE {id}/silence
+    http.HandleFunc("/api/v1/device-enrollments", deviceEnrollmentsHandler) // POST exchange enrollment token for device secret (public)
+
+    // API v1 - 认证资源（公开）
     http.HandleFunc("/api/v1/auth/sessions", authSessionsHandler)   // POST login, DELETE logout
+    http.HandleFunc("/api/v1/auth/sessions/current", authCurrentSessionHandler) // GET current session, DELETE logout
     http.HandleFunc("/api/v1/auth/users", authUsersHandler)        // POST register
+    http.HandleFunc("/api/v1/auth/users/", requireAuth(userResourceHandler)) // PUT {id}/role (admin)
     http.HandleFunc("/api/v1/auth/codes", authCodesHandler)        // POST send verification code
     http.HandleFunc("/api/v1/auth/qr-tickets", authQRTicketsHandler) // POST generate QR ticket
+    http.HandleFunc("/api/v1/auth/mfa/totp", requireAuth(mfaTOTPHandler))                // POST enroll, DELETE disable
+    http.HandleFunc("/api/v1/auth/mfa/totp/confirm", requireAuth(mfaTOTPConfirmHandler)) // POST confirm enrollment
+    http.HandleFunc("/api/v1/auth/captchas", authCaptchasHandler)  // POST new captcha
+    http.HandleFunc("/api/v1/auth/captchas/", captchaImageHandler) // GET {id}.png image
+    http.HandleFunc("/api/v1/auth/qr-tickets/", qrTicketResourceHandler) // GET long-poll state, POST {ticket}/scan|confirm|deny
+
+    // API v1 - 工作流资源（需登录或 API Key）
+    http.HandleFunc("/api/v1/workflows", requireAPIAuth("workflows", workflowsCollectionHandler)) // GET list, POST create
+    http.HandleFunc("/api/v1/workflows/", requireAPIAuth("workflows", workflowResourceHandler))   // GET/PUT/DELETE by id, POST {id}/runs
 
-    // API v1 - 工作流资源
-    http.HandleFunc("/api/v1/workflows", workflowsCollectionHandler) // GET list, POST create
-    http.HandleFunc("/api/v1/workflows/", workflowResourceHandler)   // GET/PUT/DELETE by id
+    // API v1 - 工作区（需登录）；设备与工作流按当前工作区隔离（X-Workspace-ID）
+    http.HandleFunc("/api/v1/workspaces", requireAuth(workspacesCollectionHandler)) // GET list, POST create
+    http.HandleFunc("/api/v1/workspaces/", requireAuth(workspaceResourceHandler))   // GET by id, members, invitations, activate
 
-    // API v1 - 健康与连接状态
+    // API v1 - API Key 管理（需登录）
+    http.HandleFunc("/api/v1/api-keys", requireAuth(apiKeysCollectionHandler)) // GET list, POST create
+    http.HandleFunc("/api/v1/api-keys/", requireAuth(apiKeyResourceHandler))   // DELETE revoke
+
+    // API v1 - 事件流（需登录或 API Key），按当前工作区过滤
+    http.HandleFunc("/api/v1/events/stream", requireAPIAuth("devices", eventsStreamHandler)) // GET SSE stream
+
+    // API v1 - 健康与连接状态（公开）
     http.HandleFunc("/api/v1/health", healthHandler)              // GET liveness
     http.HandleFunc("/api/v1/health/stream", healthStreamHandler) // GET SSE stream
 
-    _ = http.ListenAndServe(":8080", nil)
+    // 退出前等待存储落盘
+    go func() {
+        sig := make(chan os.Signal, 1)
+        signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+        <-sig
+        if err := store.Sync(); err != nil {
+            log.Printf("store: final sync failed: %v", err)
+        }
+        os.Exit(0)
+    }()
+
+    // 变更类请求返回前等待存储落盘
+    _ = http.ListenAndServe(":8080", durableWrites(http.DefaultServeMux))
 }
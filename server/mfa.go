@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 TOTP 参数（与主流验证器 App 默认一致）
+const (
+	totpPeriod  = 30
+	totpDigits  = 6
+	totpSkew    = 1 // 允许前后各 1 个时间步的时钟偏差
+	totpIssuer  = "collabweb"
+	recoveryNum = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// 计算指定时间步的 TOTP（HMAC-SHA1 + 动态截断）
+func totpAt(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// 校验 TOTP，返回匹配的时间步；lastStep 及之前的时间步视为已使用，防止重放
+func verifyTOTP(secretB32, code string, lastStep int64, now time.Time) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(strings.ToUpper(secretB32))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	cur := now.Unix() / totpPeriod
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpAt(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+func otpauthURI(account, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// 生成一次性恢复码，返回原文与摘要
+func newRecoveryCodes() ([]string, []string) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, recoveryNum)
+	hashes := make([]string, recoveryNum)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashToken(codes[i])
+	}
+	return codes, hashes
+}
+
+// 校验第二因素（TOTP 或恢复码）；成功时更新 u 中的防重放时间步或消费恢复码。
+// 调用方负责保存 u
+func checkSecondFactor(u *User, code string, now time.Time) bool {
+	code = strings.TrimSpace(code)
+	if step, ok := verifyTOTP(u.TOTPSecret, code, u.TOTPLastStep, now); ok {
+		u.TOTPLastStep = step
+		return true
+	}
+	h := hashToken(strings.ToLower(code))
+	for i, rc := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// POST /api/v1/auth/mfa/totp (enroll), DELETE /api/v1/auth/mfa/totp (disable)
+func mfaTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		enrollTOTP(w, r)
+	case http.MethodDelete:
+		disableTOTP(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// POST /api/v1/auth/mfa/totp/confirm (confirm enrollment, returns recovery codes)
+func mfaTOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		confirmTOTP(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// 生成待确认的密钥；确认前不影响登录
+func enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	secret := newTOTPSecret()
+	_, err := store.UpdateUser(p.UserID, func(u *User) error {
+		if u.MFAEnabled {
+			return errConflict
+		}
+		u.TOTPPendingSecret = secret
+		return nil
+	})
+	if err == errConflict {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "已启用二次验证"})
+		return
+	}
+	if err != nil {
+		writeUnauthorized(w)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, TOTPEnrollResponse{Secret: secret, OTPAuthURI: otpauthURI(p.Account, secret)})
+}
+
+func readTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return "", false
+	}
+	var req TOTPCodeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return "", false
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "验证码不能为空"})
+		return "", false
+	}
+	return strings.TrimSpace(req.Code), true
+}
+
+// 用验证器 App 生成的验证码确认绑定，启用 MFA 并返回恢复码（仅此一次）
+func confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	code, ok := readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	var recovery []string
+	_, err := store.UpdateUser(p.UserID, func(u *User) error {
+		if u.TOTPPendingSecret == "" {
+			return errNotFound
+		}
+		step, ok := verifyTOTP(u.TOTPPendingSecret, code, 0, time.Now())
+		if !ok {
+			return errBadCredentials
+		}
+		codes, hashes := newRecoveryCodes()
+		recovery = codes
+		u.TOTPSecret = u.TOTPPendingSecret
+		u.TOTPPendingSecret = ""
+		u.TOTPLastStep = step
+		u.RecoveryCodes = hashes
+		u.MFAEnabled = true
+		return nil
+	})
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "recoveryCodes": recovery})
+	case errNotFound:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "请先发起绑定"})
+	case errBadCredentials:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "验证码错误"})
+	default:
+		writeUnauthorized(w)
+	}
+}
+
+// 关闭 MFA，需提供当前 TOTP 或恢复码
+func disableTOTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	code, ok := readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := store.UpdateUser(p.UserID, func(u *User) error {
+		if !u.MFAEnabled {
+			return errNotFound
+		}
+		if !checkSecondFactor(u, code, time.Now()) {
+			return errBadCredentials
+		}
+		u.MFAEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		return nil
+	})
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusNoContent, nil)
+	case errNotFound:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "未启用二次验证"})
+	case errBadCredentials:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "验证码错误"})
+	default:
+		writeUnauthorized(w)
+	}
+}
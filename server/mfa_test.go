@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量（取 8 位结果的后 6 位）
+func TestTOTPAtRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpAt(secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpAt(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	raw := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(raw)
+	now := time.Unix(1111111111, 0)
+	cur := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		ok       bool
+		step     int64
+	}{
+		{"current step", secret, totpAt(raw, cur), 0, true, cur},
+		{"lowercase secret", strings.ToLower(secret), totpAt(raw, cur), 0, true, cur},
+		{"previous step within skew", secret, totpAt(raw, cur-1), 0, true, cur - 1},
+		{"next step within skew", secret, totpAt(raw, cur+1), 0, true, cur + 1},
+		{"two steps behind", secret, totpAt(raw, cur-2), 0, false, 0},
+		{"two steps ahead", secret, totpAt(raw, cur+2), 0, false, 0},
+		{"replay of used step", secret, totpAt(raw, cur), cur, false, 0},
+		{"older step after newer used", secret, totpAt(raw, cur-1), cur, false, 0},
+		{"newer step after older used", secret, totpAt(raw, cur+1), cur, true, cur + 1},
+		{"wrong code", secret, "000000", 0, false, 0},
+		{"wrong length", secret, totpAt(raw, cur)[:5], 0, false, 0},
+		{"invalid secret", "not base32!", totpAt(raw, cur), 0, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(tt.secret, tt.code, tt.lastStep, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("verifyTOTP = (%d, %v), want (%d, %v)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckSecondFactorTOTPReplay(t *testing.T) {
+	raw := []byte("12345678901234567890")
+	now := time.Unix(1234567890, 0)
+	u := &User{TOTPSecret: totpEncoding.EncodeToString(raw)}
+	code := totpAt(raw, now.Unix()/totpPeriod)
+
+	if !checkSecondFactor(u, code, now) {
+		t.Fatal("first use of TOTP code rejected")
+	}
+	if u.TOTPLastStep != now.Unix()/totpPeriod {
+		t.Fatalf("TOTPLastStep = %d, want %d", u.TOTPLastStep, now.Unix()/totpPeriod)
+	}
+	if checkSecondFactor(u, code, now.Add(10*time.Second)) {
+		t.Fatal("replayed TOTP code accepted")
+	}
+}
+
+func TestCheckSecondFactorRecoveryCode(t *testing.T) {
+	codes, hashes := newRecoveryCodes()
+	if len(codes) != recoveryNum || len(hashes) != recoveryNum {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryNum)
+	}
+	u := &User{TOTPSecret: newTOTPSecret(), RecoveryCodes: hashes}
+	now := time.Now()
+
+	// 恢复码不区分大小写，可带首尾空白
+	if !checkSecondFactor(u, "  "+strings.ToUpper(codes[3])+" ", now) {
+		t.Fatal("recovery code rejected")
+	}
+	if len(u.RecoveryCodes) != recoveryNum-1 {
+		t.Fatalf("%d recovery codes left, want %d", len(u.RecoveryCodes), recoveryNum-1)
+	}
+	if checkSecondFactor(u, codes[3], now) {
+		t.Fatal("recovery code accepted twice")
+	}
+	// 消费时不能改写调用方共享的底层数组
+	if hashes[3] != hashToken(codes[3]) {
+		t.Fatal("original recovery code slice was modified")
+	}
+	if !checkSecondFactor(u, codes[9], now) {
+		t.Fatal("remaining recovery code rejected")
+	}
+	if checkSecondFactor(u, "aaaaa-bbbbb", now) {
+		t.Fatal("unknown recovery code accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ctxKey int
+
+const principalKey ctxKey = iota
+
+// 已认证的调用方，由认证中间件注入请求上下文
+type Principal struct {
+	UserID      string `json:"userId"`
+	Account     string `json:"account"`
+	Role        string `json:"role"`               // 在当前工作区的有效角色
+	UserRole    string `json:"userRole"`           // 账号级角色
+	WorkspaceID string `json:"workspaceId"`        // 当前工作区，见 resolveWorkspace
+	APIKeyID    string `json:"apiKeyId,omitempty"` // 通过 API Key 认证时非空
+	DeviceID    string `json:"deviceId,omitempty"` // 通过设备密钥认证时非空，仅可调用自身的设备侧接口
+}
+
+// 认证中间件：校验 Bearer 会话令牌，失败返回 401；同时解析当前工作区
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := authenticateSession(w, r)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	}
+}
+
+// 资源接口的认证中间件：除会话令牌外也接受 API Key（cwk_ 前缀）与设备密钥（cwd_ 前缀），
+// API Key 需具备 resource 的读（GET）或写（其他方法）权限
+func requireAPIAuth(resource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p Principal
+		var ok bool
+		if token := bearerToken(r); strings.HasPrefix(token, apiKeyPrefix) {
+			p, ok = authenticateAPIKey(w, r, token, resource)
+		} else if strings.HasPrefix(token, deviceSecretPrefix) {
+			p, ok = authenticateDevice(w, r, token)
+		} else {
+			p, ok = authenticateSession(w, r)
+		}
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	}
+}
+
+// 校验会话令牌；失败时已写入响应
+func authenticateSession(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	sess, err := lookupSession(bearerToken(r))
+	if err != nil {
+		writeUnauthorized(w)
+		return Principal{}, false
+	}
+	user, ok := store.GetUser(sess.UserID)
+	if !ok {
+		writeUnauthorized(w)
+		return Principal{}, false
+	}
+	wsID, role, err := resolveWorkspace(r, user)
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Not a member of this workspace"})
+		return Principal{}, false
+	}
+	return Principal{UserID: user.ID, Account: user.Account, Role: role, UserRole: userRole(user), WorkspaceID: wsID}, true
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="collabweb"`)
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+}
+
+// 读取中间件注入的调用方
+func currentPrincipal(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalKey).(Principal)
+	return p, ok
+}
+
+// 变更类请求（GET/HEAD 以外）在返回 2xx 前等待存储落盘，落盘失败时改为返回 500，
+// 避免客户端把未保存的数据当作已成功
+func durableWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&durableWriter{ResponseWriter: w}, r)
+	})
+}
+
+type durableWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	failed      bool // 已改写为 500，丢弃处理函数后续写出的内容
+}
+
+func (d *durableWriter) WriteHeader(status int) {
+	if d.wroteHeader {
+		return
+	}
+	d.wroteHeader = true
+	if status >= 200 && status < 300 {
+		if err := store.Sync(); err != nil {
+			log.Printf("store: sync failed: %v", err)
+			d.failed = true
+			writeJSON(d.ResponseWriter, http.StatusInternalServerError, map[string]string{"error": "Failed to save changes"})
+			return
+		}
+	}
+	d.ResponseWriter.WriteHeader(status)
+}
+
+func (d *durableWriter) Write(b []byte) (int, error) {
+	if !d.wroteHeader {
+		d.WriteHeader(http.StatusOK)
+	}
+	if d.failed {
+		return len(b), nil
+	}
+	return d.ResponseWriter.Write(b)
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OTA 升级任务：按设备类型与标签选择目标设备，分阶段按累计百分比下发升级指令；
+// 设备回报升级状态，失败率超过阈值时自动暂停
+const (
+	otaRunning   = "running"
+	otaPaused    = "paused"
+	otaCompleted = "completed"
+	otaCancelled = "cancelled"
+
+	// 单台设备的升级状态
+	otaPending     = "pending"    // 尚未进入下发阶段
+	otaDispatched  = "dispatched" // 已下发 ota.update 指令
+	otaDownloading = "downloading"
+	otaInstalling  = "installing"
+	otaSucceeded   = "succeeded"
+	otaFailed      = "failed"
+	otaSkipped     = "skipped" // 设备已被删除，或任务取消时指令尚未被拉取
+
+	otaCommandName = "ota.update"
+
+	// 设备进入 dispatched 后记录指令 ID 前的窗口，超过仍未记录视为下发失败
+	otaDispatchGrace = time.Minute
+
+	defaultOTAFailureThreshold = 0.2
+	defaultOTAMinSamples       = 3
+)
+
+var defaultOTAStages = []int{10, 50, 100}
+
+type OTADeviceState struct {
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+	CommandID string `json:"commandId,omitempty"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+type OTACampaign struct {
+	ID               string  `json:"id"`
+	WorkspaceID      string  `json:"workspaceId"`
+	Name             string  `json:"name"`
+	FirmwareID       string  `json:"firmwareId"`
+	Version          string  `json:"version"`
+	DeviceType       string  `json:"deviceType"`
+	Selector         string  `json:"selector,omitempty"` // 标签选择器，为空表示该类型的全部设备
+	Stages           []int   `json:"stages"`             // 各阶段累计覆盖的百分比，最后一个为 100
+	Stage            int     `json:"stage"`              // 当前阶段下标
+	FailureThreshold float64 `json:"failureThreshold"`   // 失败率超过该值时自动暂停（0-1）
+	MinSamples       int     `json:"minSamples"`         // 至少有这么多台完成后才判断失败率
+	AutoAdvance      bool    `json:"autoAdvance"`        // 当前阶段全部完成后自动进入下一阶段
+	Status           string  `json:"status"`
+	PauseReason      string  `json:"pauseReason,omitempty"`
+
+	// 恢复任务时已确认的结果，失败率只统计之后完成的设备
+	BaselineFailed   int `json:"baselineFailed,omitempty"`
+	BaselineFinished int `json:"baselineFinished,omitempty"`
+
+	Targets []string                  `json:"targets,omitempty"` // 按稳定的伪随机顺序排列，前 N% 为对应阶段的设备
+	Devices map[string]OTADeviceState `json:"devices,omitempty"`
+
+	CreatedBy   string `json:"createdBy"`
+	CreatedAt   int64  `json:"createdAt"`
+	UpdatedAt   int64  `json:"updatedAt"`
+	CompletedAt int64  `json:"completedAt,omitempty"`
+}
+
+type OTACampaignSummary struct {
+	Total       int     `json:"total"`
+	Pending     int     `json:"pending"`
+	InProgress  int     `json:"inProgress"` // dispatched / downloading / installing
+	Succeeded   int     `json:"succeeded"`
+	Failed      int     `json:"failed"`
+	Skipped     int     `json:"skipped"`
+	FailureRate float64 `json:"failureRate"`
+	StagePct    int     `json:"stagePercent"`
+	StageSize   int     `json:"stageSize"`
+}
+
+type OTACampaignResponse struct {
+	OTACampaign
+	Summary OTACampaignSummary `json:"summary"`
+}
+
+type CreateOTACampaignRequest struct {
+	Name             string   `json:"name"`
+	FirmwareID       string   `json:"firmwareId"`
+	DeviceType       string   `json:"deviceType"` // 默认为固件的设备类型
+	Labels           string   `json:"labels"`
+	Stages           []int    `json:"stages"`
+	FailureThreshold *float64 `json:"failureThreshold"`
+	MinSamples       int      `json:"minSamples"`
+	AutoAdvance      *bool    `json:"autoAdvance"`
+}
+
+type OTAReportRequest struct {
+	CampaignID string `json:"campaignId"`
+	State      string `json:"state"` // downloading | installing | succeeded | failed
+	Error      string `json:"error"`
+}
+
+func (c *OTACampaign) stageSize() int {
+	n := int(math.Ceil(float64(len(c.Targets)) * float64(c.Stages[c.Stage]) / 100))
+	if n < 1 {
+		n = 1
+	}
+	if n > len(c.Targets) {
+		n = len(c.Targets)
+	}
+	return n
+}
+
+func (c *OTACampaign) summary() OTACampaignSummary {
+	s := OTACampaignSummary{Total: len(c.Targets), StagePct: c.Stages[c.Stage], StageSize: c.stageSize()}
+	for _, st := range c.Devices {
+		switch st.State {
+		case otaPending:
+			s.Pending++
+		case otaSucceeded:
+			s.Succeeded++
+		case otaFailed:
+			s.Failed++
+		case otaSkipped:
+			s.Skipped++
+		default:
+			s.InProgress++
+		}
+	}
+	if finished := s.Succeeded + s.Failed; finished > 0 {
+		s.FailureRate = float64(s.Failed) / float64(finished)
+	}
+	return s
+}
+
+// 失败率（不含恢复前已确认的结果）是否超过阈值
+func (c *OTACampaign) failureExceeded() (bool, float64) {
+	s := c.summary()
+	failed := s.Failed - c.BaselineFailed
+	finished := s.Succeeded + s.Failed - c.BaselineFinished
+	started := s.InProgress + finished
+	min := c.MinSamples
+	if started < min {
+		min = started
+	}
+	if failed <= 0 || finished < min {
+		return false, 0
+	}
+	rate := float64(failed) / float64(finished)
+	return rate > c.FailureThreshold, rate
+}
+
+// 推进任务：检查失败率，下发当前阶段内尚未下发的设备，阶段完成后进入下一阶段。
+// 返回需要下发指令的设备与待发布的事件类型；调用方需已复制 Devices
+func (c *OTACampaign) reconcile(now int64) ([]string, []string) {
+	var dispatch, events []string
+	for c.Status == otaRunning {
+		if exceeded, rate := c.failureExceeded(); exceeded {
+			c.Status = otaPaused
+			c.PauseReason = fmt.Sprintf("failure rate %.0f%% exceeds threshold %.0f%%", rate*100, c.FailureThreshold*100)
+			events = append(events, "ota.paused")
+			break
+		}
+		n := c.stageSize()
+		done := true
+		for _, id := range c.Targets[:n] {
+			st := c.Devices[id]
+			switch st.State {
+			case otaPending:
+				c.Devices[id] = OTADeviceState{State: otaDispatched, UpdatedAt: now}
+				dispatch = append(dispatch, id)
+				done = false
+			case otaSucceeded, otaFailed, otaSkipped:
+			default:
+				done = false
+			}
+		}
+		if !done {
+			break
+		}
+		if c.Stage == len(c.Stages)-1 {
+			c.Status = otaCompleted
+			c.CompletedAt = now
+			events = append(events, "ota.completed")
+			break
+		}
+		if !c.AutoAdvance {
+			break
+		}
+		c.Stage++
+		events = append(events, "ota.stage_advanced")
+	}
+	c.UpdatedAt = now
+	return dispatch, events
+}
+
+func cloneOTADevices(in map[string]OTADeviceState) map[string]OTADeviceState {
+	out := make(map[string]OTADeviceState, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// 在更新闭包中执行 fn 后推进任务，并为新进入阶段的设备下发升级指令
+func updateCampaign(id string, fn func(c *OTACampaign) error) (OTACampaign, error) {
+	var dispatch, events []string
+	c, err := store.UpdateOTACampaign(id, func(c *OTACampaign) error {
+		// 复制后再修改，避免与读取方共享 map
+		c.Devices = cloneOTADevices(c.Devices)
+		if fn != nil {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		dispatch, events = c.reconcile(time.Now().Unix())
+		return nil
+	})
+	if err != nil {
+		return c, err
+	}
+
+	fw, _ := store.GetFirmware(c.FirmwareID)
+	for len(dispatch) > 0 {
+		// 设备 ID -> 指令 ID，空串表示设备已删除
+		sent := map[string]string{}
+		for _, did := range dispatch {
+			sent[did] = dispatchOTACommand(c, fw, did)
+		}
+		c, err = store.UpdateOTACampaign(id, func(c *OTACampaign) error {
+			c.Devices = cloneOTADevices(c.Devices)
+			for did, cmdID := range sent {
+				st := c.Devices[did]
+				if cmdID != "" {
+					st.CommandID = cmdID
+				} else if st.State == otaDispatched {
+					st.State = otaSkipped
+				}
+				c.Devices[did] = st
+			}
+			var more []string
+			dispatch, more = c.reconcile(time.Now().Unix())
+			events = append(events, more...)
+			return nil
+		})
+		if err != nil {
+			return c, err
+		}
+	}
+
+	for _, typ := range events {
+		publishEvent(Event{
+			Type:        typ,
+			WorkspaceID: c.WorkspaceID,
+			Data:        map[string]interface{}{"campaignId": c.ID, "stage": c.Stage, "status": c.Status, "reason": c.PauseReason},
+		})
+	}
+	return c, nil
+}
+
+// 通过设备指令队列下发升级指令；设备已不存在时返回空串
+func dispatchOTACommand(c OTACampaign, fw Firmware, deviceID string) string {
+	d, ok := store.GetDevice(deviceID)
+	if !ok || d.WorkspaceID != c.WorkspaceID {
+		return ""
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"campaignId": c.ID,
+		"firmwareId": fw.ID,
+		"version":    fw.Version,
+		"size":       fw.Size,
+		"sha256":     fw.SHA256,
+		"url":        "/api/v1/devices/" + deviceID + "/firmware/" + fw.ID,
+	})
+	cmd, err := newCommand(Principal{UserID: c.CreatedBy}, deviceID, CreateCommandRequest{
+		Name:    otaCommandName,
+		Payload: payload,
+		TTL:     int(cmdMaxTTL / time.Second),
+	})
+	if err != nil {
+		return ""
+	}
+	return enqueueCommand(cmd).ID
+}
+
+// 定期检查已下发但不会再有回报的设备，使自动暂停与阶段推进不被卡住
+func otaWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		for _, c := range store.ListOTACampaigns() {
+			if stalled := otaStalledDevices(c, now); len(stalled) > 0 {
+				settleOTADevices(c.ID, stalled)
+			}
+		}
+	}
+}
+
+// 无法完成升级的设备及其结论；from 为检查时的状态，写回前据此确认设备状态未变
+type otaStall struct {
+	from  OTADeviceState
+	state string
+	err   string
+}
+
+// 设备已删除的记为 skipped；指令过期、执行失败或已不在队列中的记为 failed
+func otaStalledDevices(c OTACampaign, now time.Time) map[string]otaStall {
+	stalled := map[string]otaStall{}
+	for id, st := range c.Devices {
+		if st.State != otaDispatched && st.State != otaDownloading && st.State != otaInstalling {
+			continue
+		}
+		if d, ok := store.GetDevice(id); !ok || d.WorkspaceID != c.WorkspaceID {
+			stalled[id] = otaStall{from: st, state: otaSkipped, err: "device deleted"}
+			continue
+		}
+		if st.State != otaDispatched {
+			continue
+		}
+		if st.CommandID == "" {
+			if now.Unix()-st.UpdatedAt >= int64(otaDispatchGrace/time.Second) {
+				stalled[id] = otaStall{from: st, state: otaFailed, err: "update command was not queued"}
+			}
+			continue
+		}
+		cmd, ok := lookupCommand(st.CommandID)
+		switch {
+		case !ok || cmd.DeviceID != id:
+			stalled[id] = otaStall{from: st, state: otaFailed, err: "update command was lost"}
+		case cmd.Status == cmdExpired:
+			stalled[id] = otaStall{from: st, state: otaFailed, err: "update command expired"}
+		case cmd.Status == cmdFailed:
+			stalled[id] = otaStall{from: st, state: otaFailed, err: strings.TrimSpace("update command failed: " + cmd.Error)}
+		}
+	}
+	return stalled
+}
+
+func settleOTADevices(campaignID string, stalled map[string]otaStall) {
+	var failed []string
+	c, err := updateCampaign(campaignID, func(c *OTACampaign) error {
+		now := time.Now().Unix()
+		for id, s := range stalled {
+			if c.Devices[id] != s.from {
+				continue
+			}
+			c.Devices[id] = OTADeviceState{State: s.state, Error: s.err, CommandID: s.from.CommandID, UpdatedAt: now}
+			if s.state == otaFailed {
+				failed = append(failed, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for _, id := range failed {
+		publishEvent(Event{
+			Type:        "ota.device_failed",
+			DeviceID:    id,
+			WorkspaceID: c.WorkspaceID,
+			Data:        map[string]interface{}{"campaignId": c.ID, "version": c.Version, "error": c.Devices[id].Error},
+		})
+	}
+}
+
+// 取消任务时丢弃尚未被设备拉取的升级指令，对应设备记为 skipped
+func dropOTACommands(c OTACampaign) OTACampaign {
+	var ids []string
+	for _, st := range c.Devices {
+		if st.State == otaDispatched && st.CommandID != "" {
+			ids = append(ids, st.CommandID)
+		}
+	}
+	dropped := dropPendingCommands(ids)
+	if len(dropped) == 0 {
+		return c
+	}
+	updated, err := store.UpdateOTACampaign(c.ID, func(c *OTACampaign) error {
+		c.Devices = cloneOTADevices(c.Devices)
+		now := time.Now().Unix()
+		for id, st := range c.Devices {
+			if st.State == otaDispatched && dropped[st.CommandID] {
+				st.State = otaSkipped
+				st.Error = "campaign cancelled"
+				st.UpdatedAt = now
+				c.Devices[id] = st
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c
+	}
+	return updated
+}
+
+func otaCampaignResponse(c OTACampaign, detail bool) OTACampaignResponse {
+	resp := OTACampaignResponse{OTACampaign: c, Summary: c.summary()}
+	if !detail {
+		resp.Targets = nil
+		resp.Devices = nil
+	}
+	return resp
+}
+
+// 目标设备按 sha256(任务 ID/设备 ID) 排序，各阶段抽样稳定且分布均匀
+func otaTargets(campaignID string, devices []Device) []string {
+	keys := map[string]string{}
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		sum := sha256.Sum256([]byte(campaignID + "/" + d.ID))
+		keys[d.ID] = hex.EncodeToString(sum[:])
+		ids = append(ids, d.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return keys[ids[i]] < keys[ids[j]] })
+	return ids
+}
+
+// GET /api/v1/ota-campaigns (list), POST /api/v1/ota-campaigns (create and start)
+func otaCampaignsCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listOTACampaigns(w, r)
+	case http.MethodPost:
+		createOTACampaign(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET /api/v1/ota-campaigns/{id}, POST /api/v1/ota-campaigns/{id}/pause|resume|advance|cancel
+func otaCampaignResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/ota-campaigns/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	c, ok := store.GetOTACampaign(id)
+	if !ok || c.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Campaign not found"})
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, otaCampaignResponse(c, true))
+		return
+	}
+
+	action := parts[1]
+	if action != "pause" && action != "resume" && action != "advance" && action != "cancel" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	if !canModify(p, c.CreatedBy) {
+		writeForbidden(w)
+		return
+	}
+	c, err := updateCampaign(id, func(c *OTACampaign) error {
+		return applyOTAAction(c, action)
+	})
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if action == "cancel" {
+		c = dropOTACommands(c)
+	}
+	writeJSON(w, http.StatusOK, otaCampaignResponse(c, false))
+}
+
+func applyOTAAction(c *OTACampaign, action string) error {
+	switch action {
+	case "pause":
+		if c.Status != otaRunning {
+			return errors.New("Only running campaigns can be paused")
+		}
+		c.Status = otaPaused
+		c.PauseReason = "paused manually"
+	case "resume":
+		if c.Status != otaPaused {
+			return errors.New("Only paused campaigns can be resumed")
+		}
+		// 已有的失败视为已确认，避免恢复后立即再次暂停
+		s := c.summary()
+		c.BaselineFailed = s.Failed
+		c.BaselineFinished = s.Succeeded + s.Failed
+		c.Status = otaRunning
+		c.PauseReason = ""
+	case "advance":
+		if c.Status != otaRunning {
+			return errors.New("Only running campaigns can be advanced")
+		}
+		if c.Stage == len(c.Stages)-1 {
+			return errors.New("Campaign is already at the last stage")
+		}
+		c.Stage++
+	case "cancel":
+		if c.Status != otaRunning && c.Status != otaPaused {
+			return errors.New("Campaign is already " + c.Status)
+		}
+		c.Status = otaCancelled
+		c.CompletedAt = time.Now().Unix()
+	}
+	return nil
+}
+
+func listOTACampaigns(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	list := make([]OTACampaignResponse, 0)
+	for _, c := range store.ListOTACampaigns() {
+		if c.WorkspaceID == p.WorkspaceID {
+			list = append(list, otaCampaignResponse(c, false))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"campaigns": list, "total": len(list)})
+}
+
+func createOTACampaign(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if !canCreate(p) {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req CreateOTACampaignRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Campaign name is required"})
+		return
+	}
+	fw, ok := store.GetFirmware(strings.TrimSpace(req.FirmwareID))
+	if !ok || fw.WorkspaceID != p.WorkspaceID {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Firmware not found"})
+		return
+	}
+	deviceType := strings.TrimSpace(req.DeviceType)
+	if deviceType == "" {
+		deviceType = fw.DeviceType
+	}
+	sel, err := parseLabelSelector(req.Labels)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	stages := req.Stages
+	if len(stages) == 0 {
+		stages = defaultOTAStages
+	}
+	for i, pct := range stages {
+		if pct < 1 || pct > 100 || i > 0 && pct <= stages[i-1] {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Stages must be increasing percentages between 1 and 100"})
+			return
+		}
+	}
+	if stages[len(stages)-1] != 100 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "The last stage must be 100"})
+		return
+	}
+	threshold := defaultOTAFailureThreshold
+	if req.FailureThreshold != nil {
+		threshold = *req.FailureThreshold
+	}
+	if threshold < 0 || threshold > 1 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "failureThreshold must be between 0 and 1"})
+		return
+	}
+	minSamples := req.MinSamples
+	if minSamples <= 0 {
+		minSamples = defaultOTAMinSamples
+	}
+
+	matched := make([]Device, 0)
+	for _, d := range store.ListDevices() {
+		if d.WorkspaceID == p.WorkspaceID && strings.EqualFold(d.Type, deviceType) && sel.matches(d.Labels) {
+			matched = append(matched, d)
+		}
+	}
+	if len(matched) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "No devices match the campaign target"})
+		return
+	}
+
+	now := time.Now().Unix()
+	c := OTACampaign{
+		ID:               fmt.Sprintf("ota-%d", store.NextSeq("ota")),
+		WorkspaceID:      p.WorkspaceID,
+		Name:             name,
+		FirmwareID:       fw.ID,
+		Version:          fw.Version,
+		DeviceType:       deviceType,
+		Selector:         sel.String(),
+		Stages:           stages,
+		FailureThreshold: threshold,
+		MinSamples:       minSamples,
+		AutoAdvance:      req.AutoAdvance == nil || *req.AutoAdvance,
+		Status:           otaRunning,
+		Devices:          map[string]OTADeviceState{},
+		CreatedBy:        p.UserID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	c.Targets = otaTargets(c.ID, matched)
+	for _, id := range c.Targets {
+		c.Devices[id] = OTADeviceState{State: otaPending, UpdatedAt: now}
+	}
+	if err := store.CreateOTACampaign(c); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Campaign already exists"})
+		return
+	}
+
+	// 立即下发第一阶段
+	c, err = updateCampaign(c.ID, nil)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Campaign not found"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, otaCampaignResponse(c, false))
+}
+
+// POST /api/v1/devices/{id}/ota (device reports update state)
+// GET /api/v1/devices/{id}/firmware/{fwId} (device downloads firmware)
+func deviceOTAReportHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	d, ok := workspaceDevice(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req OTAReportRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	state := strings.ToLower(strings.TrimSpace(req.State))
+	if state != otaDownloading && state != otaInstalling && state != otaSucceeded && state != otaFailed {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "State must be downloading, installing, succeeded or failed"})
+		return
+	}
+	c, ok := store.GetOTACampaign(strings.TrimSpace(req.CampaignID))
+	if !ok || c.WorkspaceID != d.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Campaign not found"})
+		return
+	}
+
+	c, err = updateCampaign(c.ID, func(c *OTACampaign) error {
+		st, ok := c.Devices[id]
+		switch {
+		case !ok:
+			return errNotFound
+		case st.State == otaPending:
+			return errors.New("Update has not been dispatched to this device")
+		case st.State == otaSucceeded || st.State == otaFailed || st.State == otaSkipped:
+			return errors.New("Update is already " + st.State)
+		}
+		st.State = state
+		st.Error = strings.TrimSpace(req.Error)
+		st.UpdatedAt = time.Now().Unix()
+		c.Devices[id] = st
+		return nil
+	})
+	if err == errNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device is not a target of this campaign"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if state == otaSucceeded {
+		var before Device
+		if d, err := store.UpdateDevice(id, func(d *Device) error {
+			before = *d
+			d.FirmwareVersion = c.Version
+			return nil
+		}); err == nil {
+			recordDeviceHistory(p, historyUpdate, d, deviceChanges(before, d))
+		}
+	}
+	if state == otaSucceeded || state == otaFailed {
+		publishEvent(Event{
+			Type:        "ota.device_" + state,
+			DeviceID:    id,
+			WorkspaceID: c.WorkspaceID,
+			Data:        map[string]interface{}{"campaignId": c.ID, "version": c.Version, "error": strings.TrimSpace(req.Error)},
+		})
+	}
+	writeJSON(w, http.StatusOK, c.Devices[id])
+}
+
+func deviceFirmwareDownloadHandler(w http.ResponseWriter, r *http.Request, id, fwID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	p, _ := currentPrincipal(r)
+	d, ok := workspaceDevice(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	// 运维下载固件使用 GET /api/v1/firmware/{id}/download
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+	fw, ok := store.GetFirmware(fwID)
+	if !ok || fw.WorkspaceID != d.WorkspaceID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Firmware not found"})
+		return
+	}
+	serveFirmware(w, r, fw)
+}
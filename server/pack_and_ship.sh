@@ -33,7 +33,7 @@ fi
 mkdir -p "$DIST_DIR"
 
 # 2) 打包源码（排除二进制与产物目录）
-#    按需排除：dist/、server、server.exe、.git、*.tar.gz
+#    按需排除：dist/、data/（文件存储数据，避免覆盖远端）、server、server.exe、.git、*.tar.gz
 #    保留：*.go、go.mod、go.sum、*.sh、*.md 等项目文件
 
 echo "[2/3] Creating source archive: ${ARCHIVE_PATH}"
@@ -42,6 +42,7 @@ rm -f "$ARCHIVE_PATH" || true
 
 tar \
   --exclude "./${DIST_DIR}" \
+  --exclude "./data" \
   --exclude "./server" \
   --exclude "./server.exe" \
   --exclude "./.git" \
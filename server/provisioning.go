@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 设备接入：创建设备时签发一次性注册令牌（cwe_），设备用它换取长期密钥（cwd_），
+// 之后以 Authorization: Bearer cwd_... 调用面向设备的接口
+const (
+	enrollmentPrefix   = "cwe_"
+	deviceSecretPrefix = "cwd_"
+
+	credPending = "pending" // 已签发注册令牌，尚未换取密钥
+	credActive  = "active"
+	credRevoked = "revoked"
+	credNone    = "none" // 无凭据或注册令牌已过期
+)
+
+type DeviceCredential struct {
+	DeviceID            string `json:"deviceId"`
+	EnrollmentHash      string `json:"enrollmentHash,omitempty"` // 未使用的注册令牌摘要
+	EnrollmentExpiresAt int64  `json:"enrollmentExpiresAt,omitempty"`
+	SecretHash          string `json:"secretHash,omitempty"`
+	SecretPrefix        string `json:"secretPrefix,omitempty"`
+	PrevSecretHash      string `json:"prevSecretHash,omitempty"` // 轮换前的密钥，宽限期内仍有效
+	PrevExpiresAt       int64  `json:"prevExpiresAt,omitempty"`
+	EnrolledAt          int64  `json:"enrolledAt,omitempty"`
+	RotatedAt           int64  `json:"rotatedAt,omitempty"`
+	RevokedAt           int64  `json:"revokedAt,omitempty"`
+	LastUsedAt          int64  `json:"lastUsedAt,omitempty"`
+}
+
+// 凭据状态响应，不包含摘要；令牌与密钥原文仅在签发时返回一次
+type DeviceCredentialResponse struct {
+	DeviceID            string `json:"deviceId"`
+	Status              string `json:"status"` // pending | active | revoked | none
+	SecretPrefix        string `json:"secretPrefix,omitempty"`
+	EnrollmentExpiresAt int64  `json:"enrollmentExpiresAt,omitempty"`
+	EnrolledAt          int64  `json:"enrolledAt,omitempty"`
+	RotatedAt           int64  `json:"rotatedAt,omitempty"`
+	RevokedAt           int64  `json:"revokedAt,omitempty"`
+	LastUsedAt          int64  `json:"lastUsedAt,omitempty"`
+	EnrollmentToken     string `json:"enrollmentToken,omitempty"`
+	Secret              string `json:"secret,omitempty"`
+}
+
+type EnrollDeviceRequest struct {
+	Token string `json:"token"`
+}
+
+func (c DeviceCredential) status(now time.Time) string {
+	switch {
+	case c.SecretHash != "":
+		return credActive
+	case c.EnrollmentHash != "" && c.EnrollmentExpiresAt > now.Unix():
+		return credPending
+	case c.RevokedAt != 0:
+		return credRevoked
+	default:
+		return credNone
+	}
+}
+
+func deviceCredentialResponse(c DeviceCredential) DeviceCredentialResponse {
+	return DeviceCredentialResponse{
+		DeviceID:            c.DeviceID,
+		Status:              c.status(time.Now()),
+		SecretPrefix:        c.SecretPrefix,
+		EnrollmentExpiresAt: c.EnrollmentExpiresAt,
+		EnrolledAt:          c.EnrolledAt,
+		RotatedAt:           c.RotatedAt,
+		RevokedAt:           c.RevokedAt,
+		LastUsedAt:          c.LastUsedAt,
+	}
+}
+
+// 签发新的注册令牌，替换之前未使用的令牌；已有密钥在换取新密钥前继续有效
+func issueEnrollment(deviceID string) (string, DeviceCredential) {
+	token, fresh := newEnrollment(deviceID)
+	c, err := store.UpdateDeviceCredential(deviceID, func(c *DeviceCredential) error {
+		c.EnrollmentHash = fresh.EnrollmentHash
+		c.EnrollmentExpiresAt = fresh.EnrollmentExpiresAt
+		return nil
+	})
+	if err != nil {
+		c = fresh
+		store.PutDeviceCredential(c)
+	}
+	return token, c
+}
+
+// 新设备的注册令牌与凭据，由调用方保存
+func newEnrollment(deviceID string) (string, DeviceCredential) {
+	token := enrollmentPrefix + randomToken(24)
+	return token, DeviceCredential{
+		DeviceID:            deviceID,
+		EnrollmentHash:      hashToken(token),
+		EnrollmentExpiresAt: time.Now().Add(cfg.DeviceEnrollmentTTL).Unix(),
+	}
+}
+
+// 校验设备密钥并构造调用方；失败时已写入响应
+func authenticateDevice(w http.ResponseWriter, r *http.Request, token string) (Principal, bool) {
+	hash := hashToken(token)
+	c, ok := store.GetDeviceCredentialByHash(hash)
+	now := time.Now()
+	valid := ok && (c.SecretHash == hash || c.PrevSecretHash == hash && c.PrevExpiresAt > now.Unix())
+	if !valid {
+		writeUnauthorized(w)
+		return Principal{}, false
+	}
+	d, ok := store.GetDevice(c.DeviceID)
+	if !ok {
+		writeUnauthorized(w)
+		return Principal{}, false
+	}
+	p := Principal{UserID: "device:" + d.ID, Account: d.Name, Role: roleEditor, WorkspaceID: d.WorkspaceID, DeviceID: d.ID}
+	if !deviceMayAccess(p, r) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Device credentials cannot access this endpoint"})
+		return Principal{}, false
+	}
+
+	if now.Unix()-c.LastUsedAt >= int64(apiKeyTouchInterval/time.Second) {
+		_, _ = store.UpdateDeviceCredential(c.DeviceID, func(c *DeviceCredential) error {
+			c.LastUsedAt = now.Unix()
+			return nil
+		})
+	}
+	return p, true
+}
+
+// 设备密钥只能调用自身的设备侧接口。其中心跳、遥测、指令拉取/回执、OTA 回报、固件下载、
+// 孪生 delta 与 reported 只接受设备密钥（见 requireDeviceSelf）；孪生查看与密钥轮换运维也可调用
+func deviceMayAccess(p Principal, r *http.Request) bool {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/devices/"+p.DeviceID+"/")
+	if rest == r.URL.Path {
+		return false
+	}
+	parts := strings.Split(rest, "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case len(parts) == 2 && parts[0] == "firmware":
+			return true
+		case parts[0] == "twin":
+			return len(parts) == 1 || len(parts) == 2 && parts[1] == "delta"
+		}
+	case http.MethodPut, http.MethodPatch:
+		return len(parts) == 2 && parts[0] == "twin" && parts[1] == "reported"
+	case http.MethodPost:
+		switch {
+		case len(parts) == 1:
+			return parts[0] == "heartbeats" || parts[0] == "telemetry" || parts[0] == "ota"
+		case len(parts) == 2 && parts[0] == "commands":
+			return parts[1] == "pull"
+		case len(parts) == 3 && parts[0] == "commands":
+			return parts[2] == "ack"
+		case len(parts) == 2 && parts[0] == "credentials":
+			return parts[1] == "rotate"
+		}
+	}
+	return false
+}
+
+// 设备侧接口只接受设备自身的密钥，会话与 API Key 无法冒充设备；失败时已写入响应
+func requireDeviceSelf(w http.ResponseWriter, p Principal, id string) bool {
+	if p.DeviceID != id {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Device credentials required"})
+		return false
+	}
+	return true
+}
+
+// POST /api/v1/device-enrollments - exchange an enrollment token for a device secret (public)
+func deviceEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req EnrollDeviceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	token := strings.TrimSpace(req.Token)
+	if !strings.HasPrefix(token, enrollmentPrefix) {
+		writeUnauthorized(w)
+		return
+	}
+
+	hash := hashToken(token)
+	c, ok := store.GetDeviceCredentialByHash(hash)
+	if !ok || c.EnrollmentHash != hash {
+		writeUnauthorized(w)
+		return
+	}
+	d, ok := store.GetDevice(c.DeviceID)
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	secret := deviceSecretPrefix + randomToken(32)
+	now := time.Now()
+	c, err = store.UpdateDeviceCredential(c.DeviceID, func(c *DeviceCredential) error {
+		// 令牌只能使用一次，并发请求中仅第一个成功
+		if c.EnrollmentHash != hash || c.EnrollmentExpiresAt <= now.Unix() {
+			return errNotFound
+		}
+		c.EnrollmentHash = ""
+		c.EnrollmentExpiresAt = 0
+		c.SecretHash = hashToken(secret)
+		c.SecretPrefix = secret[:len(deviceSecretPrefix)+8]
+		c.PrevSecretHash = ""
+		c.PrevExpiresAt = 0
+		c.EnrolledAt = now.Unix()
+		c.RevokedAt = 0
+		return nil
+	})
+	if err != nil {
+		writeUnauthorized(w)
+		return
+	}
+	publishEvent(Event{Type: "device.enrolled", DeviceID: d.ID, WorkspaceID: d.WorkspaceID})
+
+	resp := deviceCredentialResponse(c)
+	resp.Secret = secret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// GET/DELETE /api/v1/devices/{id}/credentials (status, revoke)
+// POST /api/v1/devices/{id}/credentials/enrollment (new enrollment token)
+// POST /api/v1/devices/{id}/credentials/rotate (new secret, by operator or the device itself)
+func deviceCredentialsHandler(w http.ResponseWriter, r *http.Request, id string, parts []string) {
+	p, _ := currentPrincipal(r)
+	d, ok := workspaceDevice(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		c, ok := store.GetDeviceCredential(id)
+		if !ok {
+			c = DeviceCredential{DeviceID: id}
+		}
+		writeJSON(w, http.StatusOK, deviceCredentialResponse(c))
+	case len(parts) == 0 && r.Method == http.MethodDelete:
+		revokeDeviceCredential(w, p, d)
+	case len(parts) == 1 && parts[0] == "enrollment" && r.Method == http.MethodPost:
+		if !canModify(p, d.OwnerID) {
+			writeForbidden(w)
+			return
+		}
+		token, c := issueEnrollment(id)
+		resp := deviceCredentialResponse(c)
+		resp.EnrollmentToken = token
+		writeJSON(w, http.StatusCreated, resp)
+	case len(parts) == 1 && parts[0] == "rotate" && r.Method == http.MethodPost:
+		rotateDeviceSecret(w, p, d)
+	case len(parts) == 0 || len(parts) == 1 && (parts[0] == "enrollment" || parts[0] == "rotate"):
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+// 轮换密钥：旧密钥在 DEVICE_SECRET_GRACE 内仍可使用，便于设备切换
+func rotateDeviceSecret(w http.ResponseWriter, p Principal, d Device) {
+	if p.DeviceID != d.ID && !canModify(p, d.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	secret := deviceSecretPrefix + randomToken(32)
+	now := time.Now()
+	c, err := store.UpdateDeviceCredential(d.ID, func(c *DeviceCredential) error {
+		if c.SecretHash == "" {
+			return errNotFound
+		}
+		c.PrevSecretHash = c.SecretHash
+		c.PrevExpiresAt = now.Add(cfg.DeviceSecretGrace).Unix()
+		c.SecretHash = hashToken(secret)
+		c.SecretPrefix = secret[:len(deviceSecretPrefix)+8]
+		c.RotatedAt = now.Unix()
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Device is not enrolled"})
+		return
+	}
+	resp := deviceCredentialResponse(c)
+	resp.Secret = secret
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 吊销：密钥与未使用的注册令牌立即失效，需重新签发注册令牌才能再次接入
+func revokeDeviceCredential(w http.ResponseWriter, p Principal, d Device) {
+	if !canModify(p, d.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+	c, err := store.UpdateDeviceCredential(d.ID, func(c *DeviceCredential) error {
+		c.EnrollmentHash = ""
+		c.EnrollmentExpiresAt = 0
+		c.SecretHash = ""
+		c.SecretPrefix = ""
+		c.PrevSecretHash = ""
+		c.PrevExpiresAt = 0
+		c.RevokedAt = time.Now().Unix()
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device has no credentials"})
+		return
+	}
+	publishEvent(Event{Type: "device.credentials_revoked", DeviceID: d.ID, WorkspaceID: d.WorkspaceID})
+	writeJSON(w, http.StatusOK, deviceCredentialResponse(c))
+}
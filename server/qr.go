@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 二维码登录票据状态：pending -> scanned -> confirmed | denied；超时为 expired
+const (
+	qrPending   = "pending"
+	qrScanned   = "scanned"
+	qrConfirmed = "confirmed"
+	qrDenied    = "denied"
+	qrExpired   = "expired"
+)
+
+type qrTicket struct {
+	ID        string
+	State     string
+	ExpiresAt time.Time
+	UserID    string // 扫码用户
+	Account   string
+	token     string        // 确认后签发的会话令牌，仅交付浏览器一次
+	changed   chan struct{} // 状态变化时关闭并替换，用于唤醒长轮询
+}
+
+type QRTicketStateResponse struct {
+	Ticket    string            `json:"ticket"`
+	State     string            `json:"state"`
+	ExpiresAt int64             `json:"expiresAt"`
+	Token     string            `json:"token,omitempty"`
+	User      map[string]string `json:"user,omitempty"`
+}
+
+// ---- In-memory store for QR tickets ----
+var (
+	qrMu      sync.Mutex
+	qrTickets = map[string]*qrTicket{}
+)
+
+// 调用方需持有 qrMu
+func (t *qrTicket) setState(state string) {
+	t.State = state
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// 调用方需持有 qrMu；到期的未完成票据转为 expired
+func (t *qrTicket) refresh(now time.Time) {
+	if (t.State == qrPending || t.State == qrScanned) && !now.Before(t.ExpiresAt) {
+		t.setState(qrExpired)
+	}
+}
+
+func newQRTicket() *qrTicket {
+	now := time.Now()
+	t := &qrTicket{
+		ID:        "qr_" + randomToken(24),
+		State:     qrPending,
+		ExpiresAt: now.Add(cfg.QRTicketTTL),
+		changed:   make(chan struct{}),
+	}
+
+	qrMu.Lock()
+	defer qrMu.Unlock()
+	// 顺带清理过期较久的票据
+	for id, old := range qrTickets {
+		if now.Sub(old.ExpiresAt) > cfg.QRTicketTTL {
+			delete(qrTickets, id)
+		}
+	}
+	qrTickets[t.ID] = t
+	return t
+}
+
+// GET /api/v1/auth/qr-tickets/{ticket} (long-poll state)
+// POST /api/v1/auth/qr-tickets/{ticket}/scan|confirm|deny (authenticated client)
+func qrTicketResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/auth/qr-tickets/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		pollQRTicket(w, r, id)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	switch parts[1] {
+	case "scan", "confirm", "deny":
+		action := parts[1]
+		requireAuth(func(w http.ResponseWriter, r *http.Request) {
+			transitionQRTicket(w, r, id, action)
+		})(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+// 浏览器长轮询：?state=<已知状态>&wait=<秒>，状态变化或超时后返回；
+// 确认后首次返回时携带会话令牌
+func pollQRTicket(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	known := q.Get("state")
+	wait := 0
+	if v, err := strconv.Atoi(q.Get("wait")); err == nil && v > 0 {
+		wait = v
+	}
+	if wait > 60 {
+		wait = 60
+	}
+	deadline := time.NewTimer(time.Duration(wait) * time.Second)
+	defer deadline.Stop()
+
+	for {
+		qrMu.Lock()
+		t, ok := qrTickets[id]
+		if !ok {
+			qrMu.Unlock()
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "二维码不存在"})
+			return
+		}
+		now := time.Now()
+		t.refresh(now)
+		// 状态已变化、终态或不等待时立即返回
+		if t.State != known || t.State != qrPending && t.State != qrScanned || wait == 0 {
+			resp := QRTicketStateResponse{Ticket: t.ID, State: t.State, ExpiresAt: t.ExpiresAt.Unix()}
+			if t.State == qrConfirmed && t.token != "" {
+				resp.Token = t.token
+				resp.User = map[string]string{"id": t.UserID, "account": t.Account}
+				t.token = ""
+			}
+			qrMu.Unlock()
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+		changed := t.changed
+		expiry := time.NewTimer(t.ExpiresAt.Sub(now))
+		qrMu.Unlock()
+
+		select {
+		case <-changed:
+		case <-expiry.C:
+		case <-deadline.C:
+			wait = 0
+		case <-r.Context().Done():
+			expiry.Stop()
+			return
+		}
+		expiry.Stop()
+	}
+}
+
+// 已登录客户端扫码 / 确认 / 拒绝
+func transitionQRTicket(w http.ResponseWriter, r *http.Request, id, action string) {
+	p, _ := currentPrincipal(r)
+
+	qrMu.Lock()
+	defer qrMu.Unlock()
+
+	t, ok := qrTickets[id]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "二维码不存在"})
+		return
+	}
+	t.refresh(time.Now())
+	if t.State == qrExpired {
+		writeJSON(w, http.StatusGone, map[string]string{"error": "二维码已过期"})
+		return
+	}
+	// 已被其他用户扫码的票据不允许操作
+	if t.UserID != "" && t.UserID != p.UserID {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "二维码已被其他用户扫描"})
+		return
+	}
+
+	switch {
+	case action == "scan" && t.State == qrPending:
+		t.UserID, t.Account = p.UserID, p.Account
+		t.setState(qrScanned)
+	case action == "confirm" && t.State == qrScanned:
+		token, _ := issueSession(p.UserID, p.Account, false)
+		t.token = token
+		t.setState(qrConfirmed)
+	case action == "deny" && (t.State == qrPending || t.State == qrScanned):
+		t.UserID, t.Account = p.UserID, p.Account
+		t.setState(qrDenied)
+	default:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "当前状态不允许该操作: " + t.State})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, QRTicketStateResponse{Ticket: t.ID, State: t.State, ExpiresAt: t.ExpiresAt.Unix()})
+}
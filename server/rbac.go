@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 角色：admin 可管理全部资源与用户角色；editor 可创建资源并修改自己创建的资源；
+// viewer 只读。账号与工作区成员各有角色，有效角色见 effectiveRole
+const (
+	roleAdmin  = "admin"
+	roleEditor = "editor"
+	roleViewer = "viewer"
+)
+
+var errForbidden = errors.New("forbidden")
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func validRole(role string) bool {
+	return role == roleAdmin || role == roleEditor || role == roleViewer
+}
+
+// 旧数据中未设置角色的用户按 editor 处理
+func userRole(u User) string {
+	if u.Role == "" {
+		return roleEditor
+	}
+	return u.Role
+}
+
+// 是否可创建资源（设备、工作流、运行）
+func canCreate(p Principal) bool {
+	return p.Role == roleAdmin || p.Role == roleEditor
+}
+
+// 是否可修改 / 删除归属于 ownerID 的资源；无归属的资源（种子与 mock 数据）仅 admin 可改
+func canModify(p Principal, ownerID string) bool {
+	switch p.Role {
+	case roleAdmin:
+		return true
+	case roleEditor:
+		return ownerID != "" && ownerID == p.UserID
+	default:
+		return false
+	}
+}
+
+func writeForbidden(w http.ResponseWriter) {
+	writeJSON(w, http.StatusForbidden, map[string]string{"error": "Forbidden"})
+}
+
+// PUT /api/v1/auth/users/{id}/role (account admin only)
+func userResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/auth/users/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "role" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		updateUserRole(w, r, parts[0])
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func updateUserRole(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if p.UserRole != roleAdmin {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req UpdateRoleRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if !validRole(role) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "角色必须为 admin、editor 或 viewer"})
+		return
+	}
+	// 避免管理员误操作导致系统中没有管理员
+	if id == p.UserID {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "不能修改自己的角色"})
+		return
+	}
+
+	u, err := store.UpdateUser(id, func(u *User) error {
+		u.Role = role
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "用户不存在"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": u.ID, "account": u.Account, "role": u.Role})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errInvalidSession = errors.New("invalid or expired session")
+
+// 生成 n 字节随机数的 URL 安全编码
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// 令牌只以 SHA-256 摘要形式落盘
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 从 Authorization: Bearer <token> 中取出令牌
+func bearerToken(r *http.Request) string {
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// 创建会话并返回令牌原文
+func issueSession(userID, account string, remember bool) (string, Session) {
+	ttl := cfg.SessionTTL
+	if remember {
+		ttl = cfg.RememberTTL
+	}
+	now := time.Now()
+	token := "sess_" + randomToken(32)
+	sess := Session{
+		Key:       hashToken(token),
+		UserID:    userID,
+		Account:   account,
+		Remember:  remember,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	store.PutSession(sess)
+	return token, sess
+}
+
+// 校验令牌，过期会话顺带删除
+func lookupSession(token string) (Session, error) {
+	if token == "" {
+		return Session{}, errInvalidSession
+	}
+	sess, ok := store.GetSession(hashToken(token))
+	if !ok {
+		return Session{}, errInvalidSession
+	}
+	if sess.ExpiresAt <= time.Now().Unix() {
+		store.DeleteSession(sess.Key)
+		return Session{}, errInvalidSession
+	}
+	return sess, nil
+}
+
+func revokeSession(token string) bool {
+	if token == "" {
+		return false
+	}
+	return store.DeleteSession(hashToken(token))
+}
+
+// 定期清理过期会话
+func sessionJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		store.DeleteExpiredSessions(time.Now().Unix())
+	}
+}
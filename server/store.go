@@ -0,0 +1,1228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+)
+
+// Store 抽象服务端的持久化数据（设备、设备凭据、设备孪生、设备分组、固件与 OTA、告警、工作流、会话、用户、工作区、API Key）
+type Store interface {
+	// 自增序列，用于生成资源 ID；NextSeqRange 一次预留 n 个，返回第一个
+	NextSeq(name string) int
+	NextSeqRange(name string, n int) int
+
+	// 等待此前的全部变更写入磁盘，返回写入错误；内存实现直接返回 nil
+	Sync() error
+
+	// 设备
+	ListDevices() []Device
+	GetDevice(id string) (Device, bool)
+	PutDevice(d Device)
+	UpdateDevice(id string, fn func(d *Device) error) (Device, error)
+
+	// 批量操作，在一次加锁内完成（文件存储只需一次写盘）。
+	// CreateDevices 同时写入新设备的凭据与历史；UpdateDevices 与 TrashDevices 的结果与 ids 一一对应
+	CreateDevices(ds []Device, creds []DeviceCredential, history []DeviceHistoryEntry)
+	UpdateDevices(ids []string, fn func(d *Device) error) ([]Device, []error)
+	TrashDevices(ids []string, deletedBy string, at int64) []*Device
+
+	// 已删除设备在恢复期内移入回收站，期满后彻底清除
+	RestoreDevice(id string) (Device, error)
+	ListDeletedDevices() []DeletedDevice
+	GetDeletedDevice(id string) (DeletedDevice, bool)
+	PurgeDeletedDevice(id string) bool
+
+	// 设备变更历史，只追加
+	AppendDeviceHistory(entries ...DeviceHistoryEntry)
+	ListDeviceHistory(deviceID string) []DeviceHistoryEntry
+	PruneDeviceHistory(before int64) int
+
+	// 设备凭据，以设备 ID 为键，按令牌摘要查找
+	GetDeviceCredential(deviceID string) (DeviceCredential, bool)
+	GetDeviceCredentialByHash(hash string) (DeviceCredential, bool)
+	PutDeviceCredential(c DeviceCredential)
+	UpdateDeviceCredential(deviceID string, fn func(c *DeviceCredential) error) (DeviceCredential, error)
+	DeleteDeviceCredential(deviceID string) bool
+
+	// 设备孪生，以设备 ID 为键；UpdateDeviceTwin 在不存在时从空文档开始
+	GetDeviceTwin(deviceID string) (DeviceTwin, bool)
+	UpdateDeviceTwin(deviceID string, fn func(t *DeviceTwin) error) (DeviceTwin, error)
+	DeleteDeviceTwin(deviceID string) bool
+
+	// 设备分组
+	ListDeviceGroups() []DeviceGroup
+	GetDeviceGroup(id string) (DeviceGroup, bool)
+	CreateDeviceGroup(g DeviceGroup) error
+	UpdateDeviceGroup(id string, fn func(g *DeviceGroup) error) (DeviceGroup, error)
+	DeleteDeviceGroup(id string) bool
+
+	// 固件包与 OTA 升级任务
+	ListFirmware() []Firmware
+	GetFirmware(id string) (Firmware, bool)
+	CreateFirmware(fw Firmware) error
+	DeleteFirmware(id string) bool
+	ListOTACampaigns() []OTACampaign
+	GetOTACampaign(id string) (OTACampaign, bool)
+	CreateOTACampaign(c OTACampaign) error
+	UpdateOTACampaign(id string, fn func(c *OTACampaign) error) (OTACampaign, error)
+
+	// 设备指令（队列在 commands.go 的内存表中维护，这里保存副本以便重启后恢复）
+	ListDeviceCommands() []DeviceCommand
+	PutDeviceCommand(c DeviceCommand)
+	DeleteDeviceCommands(ids ...string)
+
+	// 告警规则与告警实例
+	ListAlertRules() []AlertRule
+	GetAlertRule(id string) (AlertRule, bool)
+	CreateAlertRule(r AlertRule) error
+	UpdateAlertRule(id string, fn func(r *AlertRule) error) (AlertRule, error)
+	DeleteAlertRule(id string) bool
+	ListAlerts() []Alert
+	GetAlert(id string) (Alert, bool)
+	CreateAlert(a Alert) error
+	UpdateAlert(id string, fn func(a *Alert) error) (Alert, error)
+	DeleteAlert(id string) bool
+	ListAlertSilences() []AlertSilence
+	GetAlertSilence(ruleID, deviceID string) (AlertSilence, bool)
+	PutAlertSilence(s AlertSilence)
+	DeleteAlertSilence(ruleID, deviceID string) bool
+
+	// 用户创建的工作流（定义 + 摘要）
+	ListWorkflowSummaries() []WorkflowSummary
+	GetWorkflow(id string) (WorkflowResponse, bool)
+	GetWorkflowSummary(id string) (WorkflowSummary, bool)
+	CreateWorkflow(s WorkflowSummary, wf WorkflowResponse) error
+	UpdateWorkflow(id string, fn func(s *WorkflowSummary, wf *WorkflowResponse) error) error
+	DeleteWorkflow(id string) bool
+
+	// 会话，以令牌摘要为键
+	PutSession(s Session)
+	GetSession(key string) (Session, bool)
+	DeleteSession(key string) bool
+	DeleteExpiredSessions(now int64) int
+
+	// 用户
+	CreateUser(u User) error
+	GetUser(id string) (User, bool)
+	GetUserByAccount(account string) (User, bool)
+	UpdateUser(id string, fn func(u *User) error) (User, error)
+
+	// 工作区与成员
+	CreateWorkspace(ws Workspace) error
+	GetWorkspace(id string) (Workspace, bool)
+	PutMember(m Membership)
+	GetMember(workspaceID, userID string) (Membership, bool)
+	ListMembers(workspaceID string) []Membership
+	ListUserMemberships(userID string) []Membership
+	DeleteMember(workspaceID, userID string) bool
+
+	// API Key，以令牌摘要查找
+	CreateAPIKey(k APIKey) error
+	GetAPIKey(id string) (APIKey, bool)
+	GetAPIKeyByHash(hash string) (APIKey, bool)
+	ListAPIKeys() []APIKey
+	UpdateAPIKey(id string, fn func(k *APIKey) error) (APIKey, error)
+}
+
+// 全局存储，main 中按配置替换
+var store Store = newMemoryStore()
+
+func openStore(c Config) (Store, error) {
+	switch c.StoreDriver {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "file":
+		return openFileStore(c.StorePath, c.StoreFlushInterval)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", c.StoreDriver)
+	}
+}
+
+// 可序列化的全部数据；文件存储直接落盘该结构
+type storeData struct {
+	Seq         map[string]int                  `json:"seq"`
+	Devices     map[string]Device               `json:"devices"`
+	Trash       map[string]DeletedDevice        `json:"deletedDevices"`
+	History     map[string][]DeviceHistoryEntry `json:"deviceHistory"`
+	Workflows   map[string]WorkflowResponse     `json:"workflows"`
+	Summaries   map[string]WorkflowSummary      `json:"summaries"`
+	Sessions    map[string]Session              `json:"sessions"`
+	Users       map[string]User                 `json:"users"`
+	Spaces      map[string]Workspace            `json:"workspaces"`
+	Members     map[string]Membership           `json:"members"` // 键为 memberKey(workspaceID, userID)
+	APIKeys     map[string]APIKey               `json:"apiKeys"`
+	Groups      map[string]DeviceGroup          `json:"deviceGroups"`
+	DeviceCreds map[string]DeviceCredential     `json:"deviceCredentials"`
+	Twins       map[string]DeviceTwin           `json:"deviceTwins"`
+	Firmware    map[string]Firmware             `json:"firmware"`
+	Campaigns   map[string]OTACampaign          `json:"otaCampaigns"`
+	AlertRules  map[string]AlertRule            `json:"alertRules"`
+	Alerts      map[string]Alert                `json:"alerts"`
+	Silences    map[string]AlertSilence         `json:"alertSilences"` // 键为 silenceKey(ruleID, deviceID)
+	Commands    map[string]DeviceCommand        `json:"deviceCommands"`
+}
+
+func newStoreData() *storeData {
+	return &storeData{
+		Seq:         map[string]int{},
+		Devices:     map[string]Device{},
+		Trash:       map[string]DeletedDevice{},
+		History:     map[string][]DeviceHistoryEntry{},
+		Workflows:   map[string]WorkflowResponse{},
+		Summaries:   map[string]WorkflowSummary{},
+		Sessions:    map[string]Session{},
+		Users:       map[string]User{},
+		Spaces:      map[string]Workspace{},
+		Members:     map[string]Membership{},
+		APIKeys:     map[string]APIKey{},
+		Groups:      map[string]DeviceGroup{},
+		DeviceCreds: map[string]DeviceCredential{},
+		Twins:       map[string]DeviceTwin{},
+		Firmware:    map[string]Firmware{},
+		Campaigns:   map[string]OTACampaign{},
+		AlertRules:  map[string]AlertRule{},
+		Alerts:      map[string]Alert{},
+		Silences:    map[string]AlertSilence{},
+		Commands:    map[string]DeviceCommand{},
+	}
+}
+
+// 默认工作区：示例设备与 mock 工作流归属于此，新注册用户自动加入
+func seedDefaultWorkspace(data *storeData) {
+	if _, ok := data.Spaces[defaultWorkspaceID]; ok {
+		return
+	}
+	data.Spaces[defaultWorkspaceID] = Workspace{
+		ID:        defaultWorkspaceID,
+		Name:      "默认工作区",
+		CreatedAt: time.Now().Unix(),
+	}
+}
+
+// 初始化一些示例设备
+func seedDevices(data *storeData) {
+	types := []string{"Sensor", "Actuator", "Gateway", "Camera"}
+	now := time.Now().Unix()
+	for i := 0; i < 50; i++ {
+		data.Seq["device"]++
+		id := fmt.Sprintf("d%012d", data.Seq["device"])
+		data.Devices[id] = Device{
+			ID:          id,
+			Name:        fmt.Sprintf("设备%03d", i+1),
+			Type:        types[i%len(types)],
+			LastOnline:  now - int64(i*60),
+			CreatedAt:   now - int64(i*3600),
+			UpdatedAt:   now - int64(i*60),
+			WorkspaceID: defaultWorkspaceID,
+		}
+	}
+}
+
+// 内存实现；flusher 非空时每次变更后通知其异步写盘（文件存储复用该实现）
+type memoryStore struct {
+	mu      sync.RWMutex
+	data    *storeData
+	flusher *storeFlusher
+
+	credsByHash map[string]string // 设备密钥/注册令牌哈希 -> 设备 ID，不落盘，加载后重建
+	keysByHash  map[string]string // API Key 令牌摘要 -> Key ID，同上
+}
+
+func newMemoryStore() *memoryStore {
+	data := newStoreData()
+	seedDefaultWorkspace(data)
+	seedDevices(data)
+	s := &memoryStore{data: data}
+	s.indexDeviceCredentials()
+	s.indexAPIKeys()
+	return s
+}
+
+// 调用方需持有写锁
+func (s *memoryStore) changed() {
+	if s.flusher != nil {
+		s.flusher.mark()
+	}
+}
+
+func (s *memoryStore) Sync() error {
+	if s.flusher == nil {
+		return nil
+	}
+	return s.flusher.wait()
+}
+
+func (s *memoryStore) NextSeq(name string) int {
+	return s.NextSeqRange(name, 1)
+}
+
+func (s *memoryStore) NextSeqRange(name string, n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := s.data.Seq[name] + 1
+	s.data.Seq[name] += n
+	s.changed()
+	return first
+}
+
+// ---- 设备 ----
+
+func (s *memoryStore) ListDevices() []Device {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Device, 0, len(s.data.Devices))
+	for _, d := range s.data.Devices {
+		list = append(list, d)
+	}
+	return list
+}
+
+func (s *memoryStore) GetDevice(id string) (Device, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.data.Devices[id]
+	return d, ok
+}
+
+func (s *memoryStore) PutDevice(d Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Devices[d.ID] = d
+	s.changed()
+}
+
+func (s *memoryStore) UpdateDevice(id string, fn func(d *Device) error) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.data.Devices[id]
+	if !ok {
+		return Device{}, errNotFound
+	}
+	if err := fn(&d); err != nil {
+		return Device{}, err
+	}
+	s.data.Devices[id] = d
+	s.changed()
+	return d, nil
+}
+
+func (s *memoryStore) CreateDevices(ds []Device, creds []DeviceCredential, history []DeviceHistoryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range ds {
+		s.data.Devices[d.ID] = d
+	}
+	for _, c := range creds {
+		s.putDeviceCredential(c)
+	}
+	for _, e := range history {
+		s.data.History[e.DeviceID] = append(s.data.History[e.DeviceID], e)
+	}
+	s.changed()
+}
+
+// 单个设备的 fn 出错只影响该设备
+func (s *memoryStore) UpdateDevices(ids []string, fn func(d *Device) error) ([]Device, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Device, len(ids))
+	errs := make([]error, len(ids))
+	n := 0
+	for i, id := range ids {
+		d, ok := s.data.Devices[id]
+		if !ok {
+			errs[i] = errNotFound
+			continue
+		}
+		if err := fn(&d); err != nil {
+			errs[i] = err
+			continue
+		}
+		s.data.Devices[id] = d
+		out[i] = d
+		n++
+	}
+	if n > 0 {
+		s.changed()
+	}
+	return out, errs
+}
+
+// 不存在的设备对应位置为 nil
+func (s *memoryStore) TrashDevices(ids []string, deletedBy string, at int64) []*Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Device, len(ids))
+	n := 0
+	for i, id := range ids {
+		d, ok := s.data.Devices[id]
+		if !ok {
+			continue
+		}
+		delete(s.data.Devices, id)
+		s.data.Trash[id] = DeletedDevice{Device: d, DeletedAt: at, DeletedBy: deletedBy}
+		out[i] = &d
+		n++
+	}
+	if n > 0 {
+		s.changed()
+	}
+	return out
+}
+
+func (s *memoryStore) RestoreDevice(id string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dd, ok := s.data.Trash[id]
+	if !ok {
+		return Device{}, errNotFound
+	}
+	if _, ok := s.data.Devices[id]; ok {
+		return Device{}, errConflict
+	}
+	delete(s.data.Trash, id)
+	s.data.Devices[id] = dd.Device
+	s.changed()
+	return dd.Device, nil
+}
+
+func (s *memoryStore) ListDeletedDevices() []DeletedDevice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]DeletedDevice, 0, len(s.data.Trash))
+	for _, dd := range s.data.Trash {
+		list = append(list, dd)
+	}
+	return list
+}
+
+func (s *memoryStore) GetDeletedDevice(id string) (DeletedDevice, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dd, ok := s.data.Trash[id]
+	return dd, ok
+}
+
+func (s *memoryStore) PurgeDeletedDevice(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Trash[id]; !ok {
+		return false
+	}
+	delete(s.data.Trash, id)
+	s.changed()
+	return true
+}
+
+// ---- 设备变更历史 ----
+
+func (s *memoryStore) AppendDeviceHistory(entries ...DeviceHistoryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		s.data.History[e.DeviceID] = append(s.data.History[e.DeviceID], e)
+	}
+	s.changed()
+}
+
+// 按追加顺序返回（副本）
+func (s *memoryStore) ListDeviceHistory(deviceID string) []DeviceHistoryEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]DeviceHistoryEntry, len(s.data.History[deviceID]))
+	copy(list, s.data.History[deviceID])
+	return list
+}
+
+// 删除 before 之前的记录，返回删除的条数
+func (s *memoryStore) PruneDeviceHistory(before int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, entries := range s.data.History {
+		// 记录按时间追加，找到第一条需保留的即可
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].At >= before })
+		if i == 0 {
+			continue
+		}
+		n += i
+		if i == len(entries) {
+			delete(s.data.History, id)
+			continue
+		}
+		s.data.History[id] = append([]DeviceHistoryEntry(nil), entries[i:]...)
+	}
+	if n > 0 {
+		s.changed()
+	}
+	return n
+}
+
+// ---- 设备凭据 ----
+
+func (s *memoryStore) GetDeviceCredential(deviceID string) (DeviceCredential, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.data.DeviceCreds[deviceID]
+	return c, ok
+}
+
+// 匹配当前密钥、轮换宽限期内的上一个密钥或未使用的注册令牌
+func (s *memoryStore) GetDeviceCredentialByHash(hash string) (DeviceCredential, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hash == "" {
+		return DeviceCredential{}, false
+	}
+	c, ok := s.data.DeviceCreds[s.credsByHash[hash]]
+	return c, ok
+}
+
+func (s *memoryStore) PutDeviceCredential(c DeviceCredential) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putDeviceCredential(c)
+	s.changed()
+}
+
+// 以下三个函数维护 credsByHash，调用方需持有写锁
+func (s *memoryStore) indexDeviceCredentials() {
+	s.credsByHash = map[string]string{}
+	for _, c := range s.data.DeviceCreds {
+		s.indexCredential(c, true)
+	}
+}
+
+func (s *memoryStore) putDeviceCredential(c DeviceCredential) {
+	if old, ok := s.data.DeviceCreds[c.DeviceID]; ok {
+		s.indexCredential(old, false)
+	}
+	s.data.DeviceCreds[c.DeviceID] = c
+	s.indexCredential(c, true)
+}
+
+func (s *memoryStore) indexCredential(c DeviceCredential, add bool) {
+	for _, h := range []string{c.SecretHash, c.PrevSecretHash, c.EnrollmentHash} {
+		switch {
+		case h == "":
+		case add:
+			s.credsByHash[h] = c.DeviceID
+		case s.credsByHash[h] == c.DeviceID:
+			delete(s.credsByHash, h)
+		}
+	}
+}
+
+func (s *memoryStore) UpdateDeviceCredential(deviceID string, fn func(c *DeviceCredential) error) (DeviceCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.data.DeviceCreds[deviceID]
+	if !ok {
+		return DeviceCredential{}, errNotFound
+	}
+	if err := fn(&c); err != nil {
+		return DeviceCredential{}, err
+	}
+	s.putDeviceCredential(c)
+	s.changed()
+	return c, nil
+}
+
+func (s *memoryStore) DeleteDeviceCredential(deviceID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.data.DeviceCreds[deviceID]
+	if !ok {
+		return false
+	}
+	s.indexCredential(c, false)
+	delete(s.data.DeviceCreds, deviceID)
+	s.changed()
+	return true
+}
+
+// ---- 设备孪生 ----
+
+func (s *memoryStore) GetDeviceTwin(deviceID string) (DeviceTwin, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.data.Twins[deviceID]
+	return t, ok
+}
+
+func (s *memoryStore) UpdateDeviceTwin(deviceID string, fn func(t *DeviceTwin) error) (DeviceTwin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.data.Twins[deviceID]
+	if !ok {
+		t = DeviceTwin{DeviceID: deviceID}
+	}
+	if err := fn(&t); err != nil {
+		return DeviceTwin{}, err
+	}
+	s.data.Twins[deviceID] = t
+	s.changed()
+	return t, nil
+}
+
+func (s *memoryStore) DeleteDeviceTwin(deviceID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Twins[deviceID]; !ok {
+		return false
+	}
+	delete(s.data.Twins, deviceID)
+	s.changed()
+	return true
+}
+
+// ---- 设备分组 ----
+
+func (s *memoryStore) ListDeviceGroups() []DeviceGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]DeviceGroup, 0, len(s.data.Groups))
+	for _, g := range s.data.Groups {
+		list = append(list, g)
+	}
+	return list
+}
+
+func (s *memoryStore) GetDeviceGroup(id string) (DeviceGroup, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.data.Groups[id]
+	return g, ok
+}
+
+func (s *memoryStore) CreateDeviceGroup(g DeviceGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Groups[g.ID]; ok {
+		return errConflict
+	}
+	s.data.Groups[g.ID] = g
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) UpdateDeviceGroup(id string, fn func(g *DeviceGroup) error) (DeviceGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.data.Groups[id]
+	if !ok {
+		return DeviceGroup{}, errNotFound
+	}
+	if err := fn(&g); err != nil {
+		return DeviceGroup{}, err
+	}
+	s.data.Groups[id] = g
+	s.changed()
+	return g, nil
+}
+
+func (s *memoryStore) DeleteDeviceGroup(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Groups[id]; !ok {
+		return false
+	}
+	delete(s.data.Groups, id)
+	s.changed()
+	return true
+}
+
+// ---- 固件与 OTA ----
+
+func (s *memoryStore) ListFirmware() []Firmware {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Firmware, 0, len(s.data.Firmware))
+	for _, fw := range s.data.Firmware {
+		list = append(list, fw)
+	}
+	return list
+}
+
+func (s *memoryStore) GetFirmware(id string) (Firmware, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fw, ok := s.data.Firmware[id]
+	return fw, ok
+}
+
+func (s *memoryStore) CreateFirmware(fw Firmware) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Firmware[fw.ID]; ok {
+		return errConflict
+	}
+	s.data.Firmware[fw.ID] = fw
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) DeleteFirmware(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Firmware[id]; !ok {
+		return false
+	}
+	delete(s.data.Firmware, id)
+	s.changed()
+	return true
+}
+
+func (s *memoryStore) ListOTACampaigns() []OTACampaign {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]OTACampaign, 0, len(s.data.Campaigns))
+	for _, c := range s.data.Campaigns {
+		list = append(list, c)
+	}
+	return list
+}
+
+func (s *memoryStore) GetOTACampaign(id string) (OTACampaign, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.data.Campaigns[id]
+	return c, ok
+}
+
+func (s *memoryStore) CreateOTACampaign(c OTACampaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Campaigns[c.ID]; ok {
+		return errConflict
+	}
+	s.data.Campaigns[c.ID] = c
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) UpdateOTACampaign(id string, fn func(c *OTACampaign) error) (OTACampaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.data.Campaigns[id]
+	if !ok {
+		return OTACampaign{}, errNotFound
+	}
+	if err := fn(&c); err != nil {
+		return OTACampaign{}, err
+	}
+	s.data.Campaigns[id] = c
+	s.changed()
+	return c, nil
+}
+
+// ---- 设备指令 ----
+
+func (s *memoryStore) ListDeviceCommands() []DeviceCommand {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]DeviceCommand, 0, len(s.data.Commands))
+	for _, c := range s.data.Commands {
+		list = append(list, c)
+	}
+	return list
+}
+
+func (s *memoryStore) PutDeviceCommand(c DeviceCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Commands[c.ID] = c
+	s.changed()
+}
+
+func (s *memoryStore) DeleteDeviceCommands(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := s.data.Commands[id]; ok {
+			delete(s.data.Commands, id)
+			n++
+		}
+	}
+	if n > 0 {
+		s.changed()
+	}
+}
+
+// ---- 告警 ----
+
+func (s *memoryStore) ListAlertRules() []AlertRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]AlertRule, 0, len(s.data.AlertRules))
+	for _, r := range s.data.AlertRules {
+		list = append(list, r)
+	}
+	return list
+}
+
+func (s *memoryStore) GetAlertRule(id string) (AlertRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.data.AlertRules[id]
+	return r, ok
+}
+
+func (s *memoryStore) CreateAlertRule(r AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.AlertRules[r.ID]; ok {
+		return errConflict
+	}
+	s.data.AlertRules[r.ID] = r
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) UpdateAlertRule(id string, fn func(r *AlertRule) error) (AlertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.data.AlertRules[id]
+	if !ok {
+		return AlertRule{}, errNotFound
+	}
+	if err := fn(&r); err != nil {
+		return AlertRule{}, err
+	}
+	s.data.AlertRules[id] = r
+	s.changed()
+	return r, nil
+}
+
+func (s *memoryStore) DeleteAlertRule(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.AlertRules[id]; !ok {
+		return false
+	}
+	delete(s.data.AlertRules, id)
+	s.changed()
+	return true
+}
+
+func (s *memoryStore) ListAlerts() []Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Alert, 0, len(s.data.Alerts))
+	for _, a := range s.data.Alerts {
+		list = append(list, a)
+	}
+	return list
+}
+
+func (s *memoryStore) GetAlert(id string) (Alert, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.data.Alerts[id]
+	return a, ok
+}
+
+func (s *memoryStore) CreateAlert(a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Alerts[a.ID]; ok {
+		return errConflict
+	}
+	s.data.Alerts[a.ID] = a
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) UpdateAlert(id string, fn func(a *Alert) error) (Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.data.Alerts[id]
+	if !ok {
+		return Alert{}, errNotFound
+	}
+	if err := fn(&a); err != nil {
+		return Alert{}, err
+	}
+	s.data.Alerts[id] = a
+	s.changed()
+	return a, nil
+}
+
+func (s *memoryStore) DeleteAlert(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Alerts[id]; !ok {
+		return false
+	}
+	delete(s.data.Alerts, id)
+	s.changed()
+	return true
+}
+
+func (s *memoryStore) ListAlertSilences() []AlertSilence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]AlertSilence, 0, len(s.data.Silences))
+	for _, sl := range s.data.Silences {
+		list = append(list, sl)
+	}
+	return list
+}
+
+func (s *memoryStore) GetAlertSilence(ruleID, deviceID string) (AlertSilence, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sl, ok := s.data.Silences[silenceKey(ruleID, deviceID)]
+	return sl, ok
+}
+
+func (s *memoryStore) PutAlertSilence(sl AlertSilence) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Silences[silenceKey(sl.RuleID, sl.DeviceID)] = sl
+	s.changed()
+}
+
+func (s *memoryStore) DeleteAlertSilence(ruleID, deviceID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := silenceKey(ruleID, deviceID)
+	if _, ok := s.data.Silences[key]; !ok {
+		return false
+	}
+	delete(s.data.Silences, key)
+	s.changed()
+	return true
+}
+
+// ---- 工作流 ----
+
+func copyWorkflow(wf WorkflowResponse) WorkflowResponse {
+	nodes := make([]WorkflowNode, len(wf.Nodes))
+	copy(nodes, wf.Nodes)
+	edges := make([]WorkflowEdge, len(wf.Edges))
+	copy(edges, wf.Edges)
+	return WorkflowResponse{Nodes: nodes, Edges: edges}
+}
+
+func (s *memoryStore) ListWorkflowSummaries() []WorkflowSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]WorkflowSummary, 0, len(s.data.Summaries))
+	for _, sum := range s.data.Summaries {
+		list = append(list, sum)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (s *memoryStore) GetWorkflow(id string) (WorkflowResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wf, ok := s.data.Workflows[id]
+	if !ok {
+		return WorkflowResponse{}, false
+	}
+	return copyWorkflow(wf), true
+}
+
+func (s *memoryStore) GetWorkflowSummary(id string) (WorkflowSummary, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sum, ok := s.data.Summaries[id]
+	return sum, ok
+}
+
+func (s *memoryStore) CreateWorkflow(sum WorkflowSummary, wf WorkflowResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Workflows[sum.ID]; ok {
+		return errConflict
+	}
+	s.data.Workflows[sum.ID] = copyWorkflow(wf)
+	s.data.Summaries[sum.ID] = sum
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) UpdateWorkflow(id string, fn func(sum *WorkflowSummary, wf *WorkflowResponse) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf, ok := s.data.Workflows[id]
+	if !ok {
+		return errNotFound
+	}
+	wf = copyWorkflow(wf)
+	sum := s.data.Summaries[id]
+	if err := fn(&sum, &wf); err != nil {
+		return err
+	}
+	s.data.Workflows[id] = wf
+	s.data.Summaries[id] = sum
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) DeleteWorkflow(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Workflows[id]; !ok {
+		return false
+	}
+	delete(s.data.Workflows, id)
+	delete(s.data.Summaries, id)
+	s.changed()
+	return true
+}
+
+// ---- 会话 ----
+
+func (s *memoryStore) PutSession(sess Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Sessions[sess.Key] = sess
+	s.changed()
+}
+
+func (s *memoryStore) GetSession(key string) (Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.data.Sessions[key]
+	return sess, ok
+}
+
+func (s *memoryStore) DeleteSession(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Sessions[key]; !ok {
+		return false
+	}
+	delete(s.data.Sessions, key)
+	s.changed()
+	return true
+}
+
+func (s *memoryStore) DeleteExpiredSessions(now int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, sess := range s.data.Sessions {
+		if sess.ExpiresAt <= now {
+			delete(s.data.Sessions, key)
+			n++
+		}
+	}
+	if n > 0 {
+		s.changed()
+	}
+	return n
+}
+
+// ---- 用户 ----
+
+func (s *memoryStore) CreateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[u.ID]; ok {
+		return errConflict
+	}
+	for _, existing := range s.data.Users {
+		if existing.Account == u.Account {
+			return errConflict
+		}
+	}
+	s.data.Users[u.ID] = u
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) GetUser(id string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.data.Users[id]
+	return u, ok
+}
+
+func (s *memoryStore) GetUserByAccount(account string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.data.Users {
+		if u.Account == account {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *memoryStore) UpdateUser(id string, fn func(u *User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.data.Users[id]
+	if !ok {
+		return User{}, errNotFound
+	}
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	s.data.Users[id] = u
+	s.changed()
+	return u, nil
+}
+
+// ---- 工作区 ----
+
+func memberKey(workspaceID, userID string) string {
+	return workspaceID + "/" + userID
+}
+
+func silenceKey(ruleID, deviceID string) string {
+	return ruleID + "/" + deviceID
+}
+
+func (s *memoryStore) CreateWorkspace(ws Workspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Spaces[ws.ID]; ok {
+		return errConflict
+	}
+	s.data.Spaces[ws.ID] = ws
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) GetWorkspace(id string) (Workspace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ws, ok := s.data.Spaces[id]
+	return ws, ok
+}
+
+func (s *memoryStore) PutMember(m Membership) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Members[memberKey(m.WorkspaceID, m.UserID)] = m
+	s.changed()
+}
+
+func (s *memoryStore) GetMember(workspaceID, userID string) (Membership, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.data.Members[memberKey(workspaceID, userID)]
+	return m, ok
+}
+
+func (s *memoryStore) ListMembers(workspaceID string) []Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Membership, 0)
+	for _, m := range s.data.Members {
+		if m.WorkspaceID == workspaceID {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+func (s *memoryStore) ListUserMemberships(userID string) []Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Membership, 0)
+	for _, m := range s.data.Members {
+		if m.UserID == userID {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].WorkspaceID < list[j].WorkspaceID })
+	return list
+}
+
+func (s *memoryStore) DeleteMember(workspaceID, userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memberKey(workspaceID, userID)
+	if _, ok := s.data.Members[key]; !ok {
+		return false
+	}
+	delete(s.data.Members, key)
+	s.changed()
+	return true
+}
+
+// ---- API Key ----
+
+func (s *memoryStore) CreateAPIKey(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.APIKeys[k.ID]; ok {
+		return errConflict
+	}
+	s.data.APIKeys[k.ID] = k
+	s.keysByHash[k.Hash] = k.ID
+	s.changed()
+	return nil
+}
+
+func (s *memoryStore) GetAPIKey(id string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.data.APIKeys[id]
+	return k, ok
+}
+
+func (s *memoryStore) GetAPIKeyByHash(hash string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hash == "" {
+		return APIKey{}, false
+	}
+	k, ok := s.data.APIKeys[s.keysByHash[hash]]
+	return k, ok
+}
+
+func (s *memoryStore) ListAPIKeys() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]APIKey, 0, len(s.data.APIKeys))
+	for _, k := range s.data.APIKeys {
+		list = append(list, k)
+	}
+	return list
+}
+
+func (s *memoryStore) UpdateAPIKey(id string, fn func(k *APIKey) error) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.data.APIKeys[id]
+	if !ok {
+		return APIKey{}, errNotFound
+	}
+	old := k.Hash
+	if err := fn(&k); err != nil {
+		return APIKey{}, err
+	}
+	if k.Hash != old {
+		delete(s.keysByHash, old)
+		s.keysByHash[k.Hash] = id
+	}
+	s.data.APIKeys[id] = k
+	s.changed()
+	return k, nil
+}
+
+// 重建 keysByHash，调用方需持有写锁
+func (s *memoryStore) indexAPIKeys() {
+	s.keysByHash = map[string]string{}
+	for _, k := range s.data.APIKeys {
+		s.keysByHash[k.Hash] = k.ID
+	}
+}
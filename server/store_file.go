@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 文件存储：内存数据 + 变更后由后台协程整体写入 JSON 文件（先写临时文件再 rename，保证原子性）。
+// 两次写盘至少间隔 interval，期间的变更合并为一次写入；变更类请求在返回前通过 Sync 等待落盘
+func openFileStore(path string, interval time.Duration) (*memoryStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("store path is required for file driver")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	s := newMemoryStore()
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		data := newStoreData()
+		if err := json.Unmarshal(raw, data); err != nil {
+			return nil, fmt.Errorf("load %s: %w", path, err)
+		}
+		fillStoreData(data)
+		s.data = data
+		s.indexDeviceCredentials()
+		s.indexAPIKeys()
+	case os.IsNotExist(err):
+		// 首次启动：以示例数据初始化并立即落盘
+		if err := writeStoreFile(path, s.data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	f := &storeFlusher{path: path, interval: interval, kick: make(chan struct{}, 1)}
+	f.cond = sync.NewCond(&f.mu)
+	s.flusher = f
+	go f.run(s)
+	return s, nil
+}
+
+type storeFlusher struct {
+	path     string
+	interval time.Duration
+	kick     chan struct{}
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	gen     uint64 // 每次变更递增
+	written uint64 // 最近一次写盘覆盖到的 gen
+	err     error  // 最近一次写盘的结果
+}
+
+// 由 memoryStore.changed 在持有写锁时调用
+func (f *storeFlusher) mark() {
+	f.mu.Lock()
+	f.gen++
+	f.mu.Unlock()
+	select {
+	case f.kick <- struct{}{}:
+	default:
+	}
+}
+
+// 等待调用前的全部变更写盘；写盘失败时返回错误（后台会继续重试）
+func (f *storeFlusher) wait() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := f.gen
+	for f.written < target {
+		f.cond.Wait()
+	}
+	return f.err
+}
+
+func (f *storeFlusher) run(s *memoryStore) {
+	var last time.Time
+	for range f.kick {
+		if d := time.Until(last.Add(f.interval)); d > 0 {
+			time.Sleep(d)
+		}
+		// 持有读锁序列化，保证快照与 gen 一致；写文件时不占用存储锁
+		s.mu.RLock()
+		f.mu.Lock()
+		gen := f.gen
+		f.mu.Unlock()
+		raw, err := json.Marshal(s.data)
+		s.mu.RUnlock()
+		if err == nil {
+			err = writeFileAtomic(f.path, raw)
+		}
+		last = time.Now()
+		if err != nil {
+			log.Printf("store: persist %s failed: %v", f.path, err)
+		}
+
+		f.mu.Lock()
+		f.written, f.err = gen, err
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		if err != nil {
+			select {
+			case f.kick <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// 兼容旧文件：缺失的集合补为空 map
+func fillStoreData(data *storeData) {
+	empty := newStoreData()
+	if data.Seq == nil {
+		data.Seq = empty.Seq
+	}
+	if data.Devices == nil {
+		data.Devices = empty.Devices
+	}
+	if data.Trash == nil {
+		data.Trash = empty.Trash
+	}
+	if data.History == nil {
+		data.History = empty.History
+	}
+	if data.Workflows == nil {
+		data.Workflows = empty.Workflows
+	}
+	if data.Summaries == nil {
+		data.Summaries = empty.Summaries
+	}
+	if data.Sessions == nil {
+		data.Sessions = empty.Sessions
+	}
+	if data.Users == nil {
+		data.Users = empty.Users
+	}
+	if data.Spaces == nil {
+		data.Spaces = empty.Spaces
+	}
+	if data.Members == nil {
+		data.Members = empty.Members
+	}
+	if data.APIKeys == nil {
+		data.APIKeys = empty.APIKeys
+	}
+	if data.Groups == nil {
+		data.Groups = empty.Groups
+	}
+	if data.DeviceCreds == nil {
+		data.DeviceCreds = empty.DeviceCreds
+	}
+	if data.Twins == nil {
+		data.Twins = empty.Twins
+	}
+	if data.Firmware == nil {
+		data.Firmware = empty.Firmware
+	}
+	if data.Campaigns == nil {
+		data.Campaigns = empty.Campaigns
+	}
+	if data.AlertRules == nil {
+		data.AlertRules = empty.AlertRules
+	}
+	if data.Alerts == nil {
+		data.Alerts = empty.Alerts
+	}
+	if data.Silences == nil {
+		data.Silences = empty.Silences
+	}
+	if data.Commands == nil {
+		data.Commands = empty.Commands
+	}
+	migrateAdmin(data)
+	migrateDefaultWorkspace(data)
+	migrateAlertSilences(data)
+	migrateInterruptedRuns(data)
+}
+
+// ADMIN_ACCOUNT 对应的用户设为管理员；引入角色之前的数据没有管理员，
+// 未配置 ADMIN_ACCOUNT 时与新部署一致，最早注册的用户成为管理员
+func migrateAdmin(data *storeData) {
+	var first *User
+	hasAdmin := false
+	for id, u := range data.Users {
+		if cfg.AdminAccount != "" && u.Account == cfg.AdminAccount && u.Role != roleAdmin {
+			u.Role = roleAdmin
+			data.Users[id] = u
+			log.Printf("store: promoted %s to admin (ADMIN_ACCOUNT)", u.Account)
+		}
+		if u.Role == roleAdmin {
+			hasAdmin = true
+		}
+		if first == nil || u.CreatedAt < first.CreatedAt || u.CreatedAt == first.CreatedAt && u.ID < first.ID {
+			u := u
+			first = &u
+		}
+	}
+	if !hasAdmin && first != nil {
+		first.Role = roleAdmin
+		data.Users[first.ID] = *first
+		log.Printf("store: no admin found, promoted %s to admin", first.Account)
+	}
+}
+
+// 工作流运行只保存在内存，重启时仍在运行的工作流标记为 failed：运行中的节点记为 failed，
+// 尚未执行的节点记为 skipped
+func migrateInterruptedRuns(data *storeData) {
+	for id, sum := range data.Summaries {
+		if sum.ActiveRun == "" {
+			continue
+		}
+		log.Printf("store: workflow %s run %s was interrupted by a restart", id, sum.ActiveRun)
+		sum.ActiveRun = ""
+		sum.Status = "failed"
+		data.Summaries[id] = sum
+		wf, ok := data.Workflows[id]
+		if !ok {
+			continue
+		}
+		for i, n := range wf.Nodes {
+			switch n.Status {
+			case "running":
+				wf.Nodes[i].Status = "failed"
+			case "pending":
+				wf.Nodes[i].Status = "skipped"
+			}
+		}
+		data.Workflows[id] = wf
+	}
+}
+
+// 静默曾保存在告警实例上，转为按规则与设备保存，告警恢复后再次触发时仍然生效
+func migrateAlertSilences(data *storeData) {
+	now := time.Now().Unix()
+	for id, a := range data.Alerts {
+		if a.SilencedUntil == 0 {
+			continue
+		}
+		key := silenceKey(a.RuleID, a.DeviceID)
+		if a.SilencedUntil > now && a.SilencedUntil > data.Silences[key].Until {
+			data.Silences[key] = AlertSilence{
+				RuleID:      a.RuleID,
+				DeviceID:    a.DeviceID,
+				WorkspaceID: a.WorkspaceID,
+				Until:       a.SilencedUntil,
+				CreatedBy:   a.SilencedBy,
+				CreatedAt:   a.UpdatedAt,
+			}
+		}
+		a.SilencedUntil, a.SilencedBy = 0, ""
+		data.Alerts[id] = a
+	}
+}
+
+// 引入工作区之前的数据：设备与工作流归入默认工作区，已有用户加入默认工作区
+func migrateDefaultWorkspace(data *storeData) {
+	seedDefaultWorkspace(data)
+	for id, d := range data.Devices {
+		if d.WorkspaceID == "" {
+			d.WorkspaceID = defaultWorkspaceID
+			data.Devices[id] = d
+		}
+	}
+	for id, sum := range data.Summaries {
+		if sum.WorkspaceID == "" {
+			sum.WorkspaceID = defaultWorkspaceID
+			data.Summaries[id] = sum
+		}
+	}
+	joined := map[string]bool{}
+	for _, m := range data.Members {
+		joined[m.UserID] = true
+	}
+	for _, u := range data.Users {
+		if !joined[u.ID] {
+			data.Members[memberKey(defaultWorkspaceID, u.ID)] = Membership{
+				WorkspaceID: defaultWorkspaceID,
+				UserID:      u.ID,
+				Account:     u.Account,
+				Role:        userRole(u),
+				JoinedAt:    u.CreatedAt,
+			}
+		}
+	}
+}
+
+func writeStoreFile(path string, data *storeData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw)
+}
+
+func writeFileAtomic(path string, raw []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
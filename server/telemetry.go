@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	telemetryMaxBatch   = 1000  // 单次上报最多点数
+	telemetryMaxBuckets = 10000 // 单次查询最多返回点数（原始点或降采样桶）
+	telemetryMaxSkew    = 5 * time.Minute
+)
+
+type TelemetryPoint struct {
+	Metric string  `json:"metric"`
+	TS     int64   `json:"ts"` // 时间戳（秒），为 0 时取服务端当前时间
+	Value  float64 `json:"value"`
+}
+
+type TelemetryBatchRequest struct {
+	Points []TelemetryPoint `json:"points"`
+}
+
+// 降采样结果：每个 step 一个桶
+type TelemetryBucket struct {
+	TS    int64   `json:"ts"` // 桶起始时间
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+type tsPoint struct {
+	TS    int64
+	Value float64
+}
+
+// ---- Embedded time-series store ----
+
+// 按 设备/指标 组织的内存时序库；配置 TELEMETRY_PATH 时以 JSON Lines 追加落盘，
+// 启动时回放，保留期清理时重写（压缩）文件
+type tsStore struct {
+	mu     sync.RWMutex
+	series map[string]map[string][]tsPoint // deviceID -> metric -> 按时间升序的点
+	path   string
+}
+
+// 落盘格式
+type tsRecord struct {
+	Device string  `json:"d"`
+	Metric string  `json:"m"`
+	TS     int64   `json:"t"`
+	Value  float64 `json:"v"`
+}
+
+var telemetry = &tsStore{series: map[string]map[string][]tsPoint{}}
+
+func openTelemetryStore(path string) (*tsStore, error) {
+	s := &tsStore{series: map[string]map[string][]tsPoint{}, path: path}
+	if path == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cutoff := time.Now().Add(-cfg.TelemetryRetention).Unix()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec tsRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			continue // 跳过损坏的行（如写入中断）
+		}
+		if rec.TS >= cutoff {
+			s.insert(rec.Device, rec.Metric, tsPoint{TS: rec.TS, Value: rec.Value})
+		}
+	}
+	return s, sc.Err()
+}
+
+// 调用方需持有写锁；同一时间戳的点覆盖旧值
+func (s *tsStore) insert(deviceID, metric string, pt tsPoint) {
+	metrics, ok := s.series[deviceID]
+	if !ok {
+		metrics = map[string][]tsPoint{}
+		s.series[deviceID] = metrics
+	}
+	pts := metrics[metric]
+	i := sort.Search(len(pts), func(i int) bool { return pts[i].TS >= pt.TS })
+	switch {
+	case i < len(pts) && pts[i].TS == pt.TS:
+		pts[i] = pt
+	case i == len(pts):
+		pts = append(pts, pt)
+	default:
+		pts = append(pts, tsPoint{})
+		copy(pts[i+1:], pts[i:])
+		pts[i] = pt
+	}
+	metrics[metric] = pts
+}
+
+func (s *tsStore) Append(deviceID string, points []TelemetryPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range points {
+		s.insert(deviceID, p.Metric, tsPoint{TS: p.TS, Value: p.Value})
+	}
+	if s.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, p := range points {
+		if err := enc.Encode(tsRecord{Device: deviceID, Metric: p.Metric, TS: p.TS, Value: p.Value}); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// 返回 [from, to] 内的点（副本）
+func (s *tsStore) Range(deviceID, metric string, from, to int64) []tsPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pts := s.series[deviceID][metric]
+	lo := sort.Search(len(pts), func(i int) bool { return pts[i].TS >= from })
+	hi := sort.Search(len(pts), func(i int) bool { return pts[i].TS > to })
+	out := make([]tsPoint, hi-lo)
+	copy(out, pts[lo:hi])
+	return out
+}
+
+// 设备已有的指标及点数
+func (s *tsStore) Metrics(deviceID string) map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[string]int{}
+	for m, pts := range s.series[deviceID] {
+		out[m] = len(pts)
+	}
+	return out
+}
+
+// 删除设备的全部数据；落盘时重写文件，避免重启回放时恢复
+func (s *tsStore) DeleteDevice(deviceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.series[deviceID]; !ok {
+		return nil
+	}
+	delete(s.series, deviceID)
+	if s.path == "" {
+		return nil
+	}
+	return s.rewrite()
+}
+
+// 删除 cutoff 之前的点；落盘时重写文件
+func (s *tsStore) Prune(cutoff int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for dev, metrics := range s.series {
+		for m, pts := range metrics {
+			i := sort.Search(len(pts), func(i int) bool { return pts[i].TS >= cutoff })
+			if i == 0 {
+				continue
+			}
+			n += i
+			if i == len(pts) {
+				delete(metrics, m)
+			} else {
+				metrics[m] = append([]tsPoint(nil), pts[i:]...)
+			}
+		}
+		if len(metrics) == 0 {
+			delete(s.series, dev)
+		}
+	}
+	if n == 0 || s.path == "" {
+		return n, nil
+	}
+	return n, s.rewrite()
+}
+
+// 调用方需持有写锁；先写临时文件再 rename
+func (s *tsStore) rewrite() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for dev, metrics := range s.series {
+		for m, pts := range metrics {
+			for _, p := range pts {
+				if err := enc.Encode(tsRecord{Device: dev, Metric: m, TS: p.TS, Value: p.Value}); err != nil {
+					f.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// 定期清理超出保留期的数据
+func telemetryJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := telemetry.Prune(time.Now().Add(-cfg.TelemetryRetention).Unix())
+		if err != nil {
+			log.Printf("telemetry: prune failed: %v", err)
+		} else if n > 0 {
+			log.Printf("telemetry: pruned %d expired points", n)
+		}
+	}
+}
+
+// 按 step 聚合为 avg/min/max；桶按 from 对齐，空桶不返回
+func downsample(pts []tsPoint, from, step int64) []TelemetryBucket {
+	out := make([]TelemetryBucket, 0)
+	for _, p := range pts {
+		start := from + (p.TS-from)/step*step
+		if n := len(out); n == 0 || out[n-1].TS != start {
+			out = append(out, TelemetryBucket{TS: start, Min: p.Value, Max: p.Value})
+		}
+		b := &out[len(out)-1]
+		b.Avg += p.Value // 先累加，最后求均值
+		b.Min = math.Min(b.Min, p.Value)
+		b.Max = math.Max(b.Max, p.Value)
+		b.Count++
+	}
+	for i := range out {
+		out[i].Avg /= float64(out[i].Count)
+	}
+	return out
+}
+
+// POST /api/v1/devices/{id}/telemetry (ingest batch), GET /api/v1/devices/{id}/telemetry (query)
+func deviceTelemetryHandler(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		queryTelemetry(w, r, id)
+	case http.MethodPost:
+		ingestTelemetry(w, r, id)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func ingestTelemetry(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := workspaceDevice(p, id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+	if !requireDeviceSelf(w, p, id) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req TelemetryBatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if len(req.Points) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "points is required"})
+		return
+	}
+	if len(req.Points) > telemetryMaxBatch {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("At most %d points per batch", telemetryMaxBatch)})
+		return
+	}
+
+	// 逐点校验，无效点跳过并在响应中说明
+	now := time.Now()
+	oldest := now.Add(-cfg.TelemetryRetention).Unix()
+	newest := now.Add(telemetryMaxSkew).Unix()
+	accepted := make([]TelemetryPoint, 0, len(req.Points))
+	rejected := make([]map[string]interface{}, 0)
+	for i, pt := range req.Points {
+		pt.Metric = strings.TrimSpace(pt.Metric)
+		if pt.TS == 0 {
+			pt.TS = now.Unix()
+		}
+		reason := ""
+		switch {
+		case pt.Metric == "":
+			reason = "metric is required"
+		case math.IsNaN(pt.Value) || math.IsInf(pt.Value, 0):
+			reason = "value must be a finite number"
+		case pt.TS < oldest:
+			reason = "timestamp is older than the retention period"
+		case pt.TS > newest:
+			reason = "timestamp is in the future"
+		}
+		if reason != "" {
+			rejected = append(rejected, map[string]interface{}{"index": i, "error": reason})
+			continue
+		}
+		accepted = append(accepted, pt)
+	}
+
+	if len(accepted) > 0 {
+		if err := telemetry.Append(id, accepted); err != nil {
+			log.Printf("telemetry: persist for %s failed: %v", id, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to store telemetry"})
+			return
+		}
+		// 上报数据同时视为一次心跳
+		if d, err := store.UpdateDevice(id, func(d *Device) error {
+			d.LastOnline = now.Unix()
+			return nil
+		}); err == nil {
+			observeOnline(d, true, false)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"accepted": len(accepted),
+		"rejected": rejected,
+	})
+}
+
+// ?metric=&from=&to=&step=；无 metric 时返回设备已有的指标，无 step 时返回原始点
+func queryTelemetry(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := workspaceDevice(p, id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+
+	q := r.URL.Query()
+	metric := strings.TrimSpace(q.Get("metric"))
+	if metric == "" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"deviceId": id, "metrics": telemetry.Metrics(id)})
+		return
+	}
+
+	parse := func(name string, def int64) (int64, bool) {
+		v := strings.TrimSpace(q.Get(name))
+		if v == "" {
+			return def, true
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil && n >= 0
+	}
+	now := time.Now().Unix()
+	to, ok1 := parse("to", now)
+	from, ok2 := parse("from", to-3600)
+	step, ok3 := parse("step", 0)
+	if !ok1 || !ok2 || !ok3 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from, to and step must be non-negative integers (unix seconds)"})
+		return
+	}
+	if from > to {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "from must not be after to"})
+		return
+	}
+
+	pts := telemetry.Range(id, metric, from, to)
+	resp := map[string]interface{}{
+		"deviceId": id,
+		"metric":   metric,
+		"from":     from,
+		"to":       to,
+		"step":     step,
+	}
+	if step == 0 {
+		if len(pts) > telemetryMaxBuckets {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Too many points, specify a larger step"})
+			return
+		}
+		raw := make([]map[string]interface{}, len(pts))
+		for i, pt := range pts {
+			raw[i] = map[string]interface{}{"ts": pt.TS, "value": pt.Value}
+		}
+		resp["points"] = raw
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if (to-from)/step >= telemetryMaxBuckets {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Too many buckets, specify a larger step"})
+		return
+	}
+	resp["points"] = downsample(pts, from, step)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 设备孪生：desired 由运维通过 API 设置，reported 由设备上报，两者均为 JSON 对象，
+// 各自维护版本号用于乐观并发控制；delta 为 desired 中尚未被 reported 满足的部分
+const maxTwinSection = 32 << 10
+
+// 已删除键的记录保留的 desired 版本数；更早的 since 无法增量应答，改为下发完整 desired
+const twinDeletedKeyVersions = 100
+
+type DeviceTwin struct {
+	DeviceID          string           `json:"deviceId"`
+	Desired           json.RawMessage  `json:"desired,omitempty"`
+	DesiredVersion    int64            `json:"desiredVersion"`
+	DesiredUpdatedAt  int64            `json:"desiredUpdatedAt,omitempty"`
+	Reported          json.RawMessage  `json:"reported,omitempty"`
+	ReportedVersion   int64            `json:"reportedVersion"`
+	ReportedUpdatedAt int64            `json:"reportedUpdatedAt,omitempty"`
+	DesiredKeys       map[string]int64 `json:"desiredKeys,omitempty"`      // desired 顶层键 -> 最近修改时的版本，含近期删除的键
+	DesiredKeysFloor  int64            `json:"desiredKeysFloor,omitempty"` // 已清理的删除记录中最新的版本，since 早于它时返回完整 desired
+}
+
+type DeviceTwinResponse struct {
+	DeviceID          string          `json:"deviceId"`
+	Desired           json.RawMessage `json:"desired"`
+	DesiredVersion    int64           `json:"desiredVersion"`
+	DesiredUpdatedAt  int64           `json:"desiredUpdatedAt,omitempty"`
+	Reported          json.RawMessage `json:"reported"`
+	ReportedVersion   int64           `json:"reportedVersion"`
+	ReportedUpdatedAt int64           `json:"reportedUpdatedAt,omitempty"`
+	Delta             json.RawMessage `json:"delta"`
+}
+
+// PUT 整体替换，PATCH 按 RFC 7396 合并；version 提供时须等于当前版本，否则返回 409
+type UpdateTwinRequest struct {
+	Desired  json.RawMessage `json:"desired"`
+	Reported json.RawMessage `json:"reported"`
+	Version  *int64          `json:"version"`
+}
+
+type TwinDeltaResponse struct {
+	Version int64           `json:"version"` // 当前 desired 版本，设备应用后作为下次的 since
+	Delta   json.RawMessage `json:"delta"`
+	Full    bool            `json:"full,omitempty"` // since 超前于当前版本或早于 DesiredKeysFloor 时返回完整 desired
+}
+
+var errTwinVersion = errors.New("Twin version conflict")
+
+func twinObject(raw json.RawMessage) map[string]interface{} {
+	obj := map[string]interface{}{}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &obj)
+	}
+	return obj
+}
+
+func twinJSON(obj map[string]interface{}) json.RawMessage {
+	out, _ := json.Marshal(obj)
+	return out
+}
+
+// desired 中与 reported 不一致的键；对象递归比较，reported 中多出的键忽略
+func twinDelta(desired, reported map[string]interface{}) map[string]interface{} {
+	delta := map[string]interface{}{}
+	for k, dv := range desired {
+		rv, ok := reported[k]
+		dm, dIsObj := dv.(map[string]interface{})
+		rm, rIsObj := rv.(map[string]interface{})
+		switch {
+		case ok && dIsObj && rIsObj:
+			if sub := twinDelta(dm, rm); len(sub) > 0 {
+				delta[k] = sub
+			}
+		case !ok || !reflect.DeepEqual(dv, rv):
+			delta[k] = dv
+		}
+	}
+	return delta
+}
+
+func deviceTwinResponse(t DeviceTwin) DeviceTwinResponse {
+	desired, reported := twinObject(t.Desired), twinObject(t.Reported)
+	return DeviceTwinResponse{
+		DeviceID:          t.DeviceID,
+		Desired:           twinJSON(desired),
+		DesiredVersion:    t.DesiredVersion,
+		DesiredUpdatedAt:  t.DesiredUpdatedAt,
+		Reported:          twinJSON(reported),
+		ReportedVersion:   t.ReportedVersion,
+		ReportedUpdatedAt: t.ReportedUpdatedAt,
+		Delta:             twinJSON(twinDelta(desired, reported)),
+	}
+}
+
+// 计算新的 section：replace 时 patch 即完整文档，否则按 merge patch 合并
+func applyTwinSection(current, patch json.RawMessage, replace bool) (map[string]interface{}, error) {
+	patch = bytes.TrimSpace(patch)
+	if len(patch) > maxTwinSection {
+		return nil, errors.New("Twin section must not exceed 32KB")
+	}
+	var pm map[string]interface{}
+	if err := json.Unmarshal(patch, &pm); err != nil {
+		return nil, errors.New("Twin section must be a JSON object")
+	}
+	var out map[string]interface{}
+	if replace {
+		out = mergePatch(nil, pm) // 去掉值为 null 的键
+	} else {
+		out = mergePatch(twinObject(current), pm)
+	}
+	if len(twinJSON(out)) > maxTwinSection {
+		return nil, errors.New("Twin section must not exceed 32KB")
+	}
+	return out, nil
+}
+
+// GET /api/v1/devices/{id}/twin
+// PUT/PATCH /api/v1/devices/{id}/twin/desired (operator), PUT/PATCH /api/v1/devices/{id}/twin/reported (device)
+// GET /api/v1/devices/{id}/twin/delta?since={version} (device)
+func deviceTwinHandler(w http.ResponseWriter, r *http.Request, id string, parts []string) {
+	p, _ := currentPrincipal(r)
+	d, ok := workspaceDevice(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Device not found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		t, ok := store.GetDeviceTwin(id)
+		if !ok {
+			t = DeviceTwin{DeviceID: id}
+		}
+		writeJSON(w, http.StatusOK, deviceTwinResponse(t))
+	case len(parts) == 1 && parts[0] == "delta" && r.Method == http.MethodGet:
+		if !requireDeviceSelf(w, p, id) {
+			return
+		}
+		getTwinDelta(w, r, id)
+	case len(parts) == 1 && (parts[0] == "desired" || parts[0] == "reported") &&
+		(r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		updateTwinSection(w, r, p, d, parts[0])
+	case len(parts) == 0 || len(parts) == 1 && (parts[0] == "delta" || parts[0] == "desired" || parts[0] == "reported"):
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+func updateTwinSection(w http.ResponseWriter, r *http.Request, p Principal, d Device, section string) {
+	// desired 仅运维可改；reported 只接受设备自身上报，避免伪造收敛清空 delta
+	if section == "reported" && !requireDeviceSelf(w, p, d.ID) {
+		return
+	}
+	if section == "desired" && !canModify(p, d.OwnerID) {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req UpdateTwinRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	patch := req.Desired
+	if section == "reported" {
+		patch = req.Reported
+	}
+	if len(patch) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Field " + section + " is required"})
+		return
+	}
+
+	replace := r.Method == http.MethodPut
+	now := time.Now().Unix()
+	changed := false
+	var current int64
+	t, err := store.UpdateDeviceTwin(d.ID, func(t *DeviceTwin) error {
+		cur, ver := t.Desired, t.DesiredVersion
+		if section == "reported" {
+			cur, ver = t.Reported, t.ReportedVersion
+		}
+		if req.Version != nil && *req.Version != ver {
+			current = ver
+			return errTwinVersion
+		}
+		next, err := applyTwinSection(cur, patch, replace)
+		if err != nil {
+			return err
+		}
+		prev := twinObject(cur)
+		if reflect.DeepEqual(prev, next) {
+			return nil
+		}
+		changed = true
+
+		if section == "reported" {
+			t.Reported = twinJSON(next)
+			t.ReportedVersion++
+			t.ReportedUpdatedAt = now
+			return nil
+		}
+		t.Desired = twinJSON(next)
+		t.DesiredVersion++
+		t.DesiredUpdatedAt = now
+		// 记录本次变更的顶层键，供设备按版本增量拉取
+		keys := make(map[string]int64, len(t.DesiredKeys)+len(next))
+		for k, v := range t.DesiredKeys {
+			keys[k] = v
+		}
+		for k, v := range next {
+			if pv, ok := prev[k]; !ok || !reflect.DeepEqual(pv, v) {
+				keys[k] = t.DesiredVersion
+			}
+		}
+		for k := range prev {
+			if _, ok := next[k]; !ok {
+				keys[k] = t.DesiredVersion
+			}
+		}
+		// 清理过旧的删除记录，避免键集合随删除无限增长
+		for k, v := range keys {
+			if _, ok := next[k]; !ok && t.DesiredVersion-v >= twinDeletedKeyVersions {
+				delete(keys, k)
+				if v > t.DesiredKeysFloor {
+					t.DesiredKeysFloor = v
+				}
+			}
+		}
+		t.DesiredKeys = keys
+		return nil
+	})
+	if err == errTwinVersion {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": "Version conflict", "version": current})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp := deviceTwinResponse(t)
+	if changed {
+		version := t.DesiredVersion
+		if section == "reported" {
+			version = t.ReportedVersion
+		}
+		publishEvent(Event{
+			Type:        "twin." + section + "_updated",
+			DeviceID:    d.ID,
+			WorkspaceID: d.WorkspaceID,
+			Data:        map[string]interface{}{"version": version, "delta": resp.Delta},
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 不带 since 时返回 desired 与 reported 的完整差异；带 since 时返回该版本之后变更的
+// desired 顶层键（已删除的键为 null），没有变更时返回 204
+func getTwinDelta(w http.ResponseWriter, r *http.Request, id string) {
+	t, ok := store.GetDeviceTwin(id)
+	if !ok {
+		t = DeviceTwin{DeviceID: id}
+	}
+	desired := twinObject(t.Desired)
+
+	raw := strings.TrimSpace(r.URL.Query().Get("since"))
+	if raw == "" {
+		delta := twinDelta(desired, twinObject(t.Reported))
+		writeJSON(w, http.StatusOK, TwinDeltaResponse{Version: t.DesiredVersion, Delta: twinJSON(delta)})
+		return
+	}
+	since, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || since < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid since"})
+		return
+	}
+	if since > t.DesiredVersion || since < t.DesiredKeysFloor {
+		// 设备记录的版本比服务端新（如数据被重置），或早到其间删除的键已无记录，下发完整 desired
+		writeJSON(w, http.StatusOK, TwinDeltaResponse{Version: t.DesiredVersion, Delta: twinJSON(desired), Full: true})
+		return
+	}
+	if since == t.DesiredVersion {
+		writeJSON(w, http.StatusNoContent, nil)
+		return
+	}
+	delta := map[string]interface{}{}
+	for k, v := range t.DesiredKeys {
+		if v > since {
+			delta[k] = desired[k] // 已删除的键为 nil，序列化为 null
+		}
+	}
+	writeJSON(w, http.StatusOK, TwinDeltaResponse{Version: t.DesiredVersion, Delta: twinJSON(delta)})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errBadCredentials = errors.New("invalid account or password")
+	errAccountLocked  = errors.New("account locked")
+	errMFARequired    = errors.New("mfa code required")
+	errBadMFA         = errors.New("invalid mfa code")
+)
+
+// 账号统一小写、去空白，保证唯一性判断一致
+func normalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// bcrypt 只接受不超过 72 字节的密码
+const maxPasswordBytes = 72
+
+func hashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// 账号不存在时也做一次 bcrypt 比较，避免通过耗时差异探测账号
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hashPassword(randomToken(16))
+	})
+	return dummyHash
+}
+
+// 注册新用户；邮箱已存在时返回 errConflict
+func registerUser(account, password string) (User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	// 第一个注册的用户与 ADMIN_ACCOUNT 为管理员，其余默认 editor
+	seq := store.NextSeq("user")
+	role := roleEditor
+	if seq == 1 || cfg.AdminAccount != "" && normalizeAccount(account) == cfg.AdminAccount {
+		role = roleAdmin
+	}
+	u := User{
+		ID:           fmt.Sprintf("u%012d", seq),
+		Account:      normalizeAccount(account),
+		PasswordHash: hash,
+		CreatedAt:    time.Now().Unix(),
+		Role:         role,
+	}
+	u.ActiveWorkspace = defaultWorkspaceID
+	if err := store.CreateUser(u); err != nil {
+		return User{}, err
+	}
+	joinDefaultWorkspace(u)
+	return u, nil
+}
+
+// 校验账号密码，已启用 MFA 的用户还需校验 mfa（TOTP 或恢复码）；
+// 密码或 MFA 连续失败 cfg.LoginMaxFailures 次后锁定 cfg.LoginLockout。
+// 锁定时返回的 User 携带 LockedUntil
+func authenticate(account, password, mfa string) (User, error) {
+	u, ok := store.GetUserByAccount(normalizeAccount(account))
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash()), []byte(password))
+		return User{}, errBadCredentials
+	}
+
+	now := time.Now()
+	if u.LockedUntil > now.Unix() {
+		return u, errAccountLocked
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return recordLoginFailure(u.ID, now, errBadCredentials)
+	}
+
+	// 密码正确但未提供 MFA 时不计入失败次数
+	if u.MFAEnabled && strings.TrimSpace(mfa) == "" {
+		return u, errMFARequired
+	}
+
+	// 校验成功：清零失败计数，并保存 MFA 防重放状态
+	var mfaOK bool
+	updated, err := store.UpdateUser(u.ID, func(u *User) error {
+		if u.MFAEnabled && !checkSecondFactor(u, mfa, now) {
+			return errBadMFA
+		}
+		mfaOK = true
+		u.FailedLogins = 0
+		u.LockedUntil = 0
+		return nil
+	})
+	if !mfaOK {
+		return recordLoginFailure(u.ID, now, errBadMFA)
+	}
+	return updated, err
+}
+
+// 记录一次登录失败，达到阈值后锁定账号
+func recordLoginFailure(userID string, now time.Time, cause error) (User, error) {
+	u, _ := store.UpdateUser(userID, func(u *User) error {
+		u.FailedLogins++
+		if u.FailedLogins >= cfg.LoginMaxFailures {
+			u.FailedLogins = 0
+			u.LockedUntil = now.Add(cfg.LoginLockout).Unix()
+		}
+		return nil
+	})
+	if u.LockedUntil > now.Unix() {
+		return u, errAccountLocked
+	}
+	return u, cause
+}
@@ -7,7 +7,6 @@ import (
     "net/http"
     "strconv"
     "strings"
-    "sync"
 )
 
 type WorkflowNode struct {
@@ -420,14 +419,6 @@ type WorkflowResponse struct {
     Edges []WorkflowEdge `json:"edges"`
 }
 
-// ---- In-memory store for user-created workflows ----
-var (
-    createdMu        sync.RWMutex
-    createdWorkflows = map[string]WorkflowResponse{}
-    createdSummaries = map[string]WorkflowSummary{}
-    createdSeq       = 0
-)
-
 type CreateWorkflowRequest struct {
     ID     string           `json:"id"`
     Name   string           `json:"name"`
@@ -438,10 +429,13 @@ type CreateWorkflowRequest struct {
 
 // Workflow summary for listing
 type WorkflowSummary struct {
-    ID     string `json:"id"`
-    Name   string `json:"name"`
-    Status string `json:"status"`
-    Desc   string `json:"desc"`
+    ID          string `json:"id"`
+    Name        string `json:"name"`
+    Status      string `json:"status"`
+    Desc        string `json:"desc"`
+    OwnerID     string `json:"ownerId,omitempty"`     // 创建者用户 ID，mock 数据为空
+    WorkspaceID string `json:"workspaceId,omitempty"` // 所属工作区，mock 数据属于默认工作区
+    ActiveRun   string `json:"activeRun,omitempty"`   // 正在同步进度的运行 ID，重启后据此重置中断的运行
 }
 
 // mock workflow data (same layout as the current Vue demo)
@@ -543,6 +537,7 @@ func workflowsCollectionHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GET /api/v1/workflows/{id}, PUT /api/v1/workflows/{id}, DELETE /api/v1/workflows/{id}
+// POST/GET /api/v1/workflows/{id}/runs, GET /api/v1/workflows/{id}/runs/{runId}
 func workflowResourceHandler(w http.ResponseWriter, r *http.Request) {
     // 提取工作流 ID
     path := r.URL.Path
@@ -551,12 +546,32 @@ func workflowResourceHandler(w http.ResponseWriter, r *http.Request) {
         writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
         return
     }
-    id := strings.TrimPrefix(path, prefix)
-    if id == "" || strings.Contains(id, "/") {
+    parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+    id := parts[0]
+    if id == "" {
         writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid workflow ID"})
         return
     }
 
+    // 不属于调用方当前工作区的工作流视为不存在
+    p, _ := currentPrincipal(r)
+    if !workflowVisible(p, id) {
+        writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workflow not found"})
+        return
+    }
+
+    // 子资源：/api/v1/workflows/{id}/runs[/{runId}]
+    if len(parts) > 1 {
+        if parts[1] == "runs" && len(parts) <= 3 {
+            runID := ""
+            if len(parts) == 3 { runID = parts[2] }
+            workflowRunsHandler(w, r, id, runID)
+            return
+        }
+        writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+        return
+    }
+
     switch r.Method {
     case http.MethodGet:
         getWorkflow(w, r, id)
@@ -570,14 +585,26 @@ func workflowResourceHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// 用户创建的工作流按所属工作区可见；mock 工作流仅在默认工作区可见
+func workflowVisible(p Principal, id string) bool {
+    if s, ok := store.GetWorkflowSummary(id); ok {
+        return s.WorkspaceID == p.WorkspaceID
+    }
+    return p.WorkspaceID == defaultWorkspaceID
+}
+
 func getWorkflowsList(w http.ResponseWriter, r *http.Request) {
-    list := mockWorkflowList()
-    // 合并用户创建的工作流摘要
-    createdMu.RLock()
-    for _, s := range createdSummaries {
-        list = append(list, s)
+    p, _ := currentPrincipal(r)
+    list := []WorkflowSummary{}
+    if p.WorkspaceID == defaultWorkspaceID {
+        list = mockWorkflowList()
+    }
+    // 合并当前工作区内用户创建的工作流摘要
+    for _, s := range store.ListWorkflowSummaries() {
+        if s.WorkspaceID == p.WorkspaceID {
+            list = append(list, s)
+        }
     }
-    createdMu.RUnlock()
 
     // 排序与分页参数（REST 风格：下划线命名）
     page := 1
@@ -641,6 +668,12 @@ func getWorkflowsList(w http.ResponseWriter, r *http.Request) {
 }
 
 func createWorkflow(w http.ResponseWriter, r *http.Request) {
+    p, _ := currentPrincipal(r)
+    if !canCreate(p) {
+        writeForbidden(w)
+        return
+    }
+
     body, err := io.ReadAll(r.Body)
     if err != nil {
         writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
@@ -653,62 +686,24 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // 验证必填字段
-    if len(req.Nodes) == 0 {
-        writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "At least one node is required"})
+    // 校验图结构（节点、边、环）
+    if problems := validateWorkflowGraph(req.Nodes, req.Edges); len(problems) > 0 {
+        writeGraphProblems(w, problems)
         return
     }
-
-    // 验证节点 ID 唯一性
-    ids := map[string]bool{}
-    for i, n := range req.Nodes {
-        if strings.TrimSpace(n.ID) == "" {
-            writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Node ID is required"})
-            return
-        }
-        if ids[n.ID] {
-            writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Duplicate node ID: " + n.ID})
-            return
-        }
-        ids[n.ID] = true
-        // 设置默认状态
-        if n.Status == "" {
+    // 设置默认状态
+    for i := range req.Nodes {
+        if req.Nodes[i].Status == "" {
             req.Nodes[i].Status = "pending"
         }
     }
 
-    // 过滤无效边
-    edgeOK := make([]WorkflowEdge, 0, len(req.Edges))
-    for _, e := range req.Edges {
-        if e.From == "" || e.To == "" {
-            continue
-        }
-        if !ids[e.From] || !ids[e.To] {
-            continue
-        }
-        edgeOK = append(edgeOK, e)
-    }
-
-    createdMu.Lock()
-    defer createdMu.Unlock()
-
     // 生成或验证 ID
     id := strings.TrimSpace(req.ID)
     if id == "" {
-        createdSeq++
-        id = fmt.Sprintf("user-wf-%d", createdSeq)
+        id = fmt.Sprintf("user-wf-%d", store.NextSeq("workflow"))
     }
 
-    // 检查 ID 冲突
-    if _, ok := createdWorkflows[id]; ok {
-        writeJSON(w, http.StatusConflict, map[string]string{"error": "Workflow ID already exists"})
-        return
-    }
-
-    // 创建工作流
-    wf := WorkflowResponse{Nodes: req.Nodes, Edges: edgeOK}
-    createdWorkflows[id] = wf
-
     name := strings.TrimSpace(req.Name)
     if name == "" {
         name = id
@@ -719,34 +714,40 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
         status = req.Nodes[0].Status
     }
 
-    createdSummaries[id] = WorkflowSummary{
-        ID:     id,
-        Name:   name,
-        Desc:   desc,
-        Status: status,
+    // 创建工作流（检查 ID 冲突）
+    summary := WorkflowSummary{
+        ID:          id,
+        Name:        name,
+        Desc:        desc,
+        Status:      status,
+        OwnerID:     p.UserID,
+        WorkspaceID: p.WorkspaceID,
+    }
+    if err := store.CreateWorkflow(summary, WorkflowResponse{Nodes: req.Nodes, Edges: req.Edges}); err != nil {
+        writeJSON(w, http.StatusConflict, map[string]string{"error": "Workflow ID already exists"})
+        return
     }
 
     // 返回创建的资源
     response := map[string]interface{}{
-        "id":       id,
-        "name":     name,
-        "desc":     desc,
-        "status":   status,
-        "nodes":    len(req.Nodes),
-        "edges":    len(edgeOK),
+        "id":          id,
+        "name":        name,
+        "desc":        desc,
+        "status":      status,
+        "nodes":       len(req.Nodes),
+        "edges":       len(req.Edges),
+        "ownerId":     p.UserID,
+        "workspaceId": p.WorkspaceID,
     }
     writeJSON(w, http.StatusCreated, response)
 }
 
 func getWorkflow(w http.ResponseWriter, r *http.Request, id string) {
     // 优先返回用户创建的工作流
-    createdMu.RLock()
-    if wf, ok := createdWorkflows[id]; ok {
-        createdMu.RUnlock()
+    if wf, ok := store.GetWorkflow(id); ok {
         writeJSON(w, http.StatusOK, wf)
         return
     }
-    createdMu.RUnlock()
 
     // 回退到 mock 数据
     wf := mockWorkflowByID(id)
@@ -766,54 +767,31 @@ func updateWorkflow(w http.ResponseWriter, r *http.Request, id string) {
         return
     }
 
-    createdMu.Lock()
-    defer createdMu.Unlock()
-
-    // 检查工作流是否存在（仅支持更新用户创建的工作流）
-    if _, ok := createdWorkflows[id]; !ok {
+    // 检查工作流是否存在（仅支持更新用户创建的工作流）及调用方权限
+    p, _ := currentPrincipal(r)
+    cur, ok := store.GetWorkflowSummary(id)
+    if !ok {
         writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workflow not found or not editable"})
         return
     }
-
-    // 验证节点（与创建时相同的逻辑）
-    if len(req.Nodes) == 0 {
-        writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "At least one node is required"})
+    if !canModify(p, cur.OwnerID) {
+        writeForbidden(w)
         return
     }
 
-    ids := map[string]bool{}
-    for i, n := range req.Nodes {
-        if strings.TrimSpace(n.ID) == "" {
-            writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Node ID is required"})
-            return
-        }
-        if ids[n.ID] {
-            writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Duplicate node ID: " + n.ID})
-            return
-        }
-        ids[n.ID] = true
-        if n.Status == "" {
-            req.Nodes[i].Status = "pending"
-        }
+    // 校验图结构（与创建时相同的逻辑）
+    if problems := validateWorkflowGraph(req.Nodes, req.Edges); len(problems) > 0 {
+        writeGraphProblems(w, problems)
+        return
     }
-
-    // 过滤无效边
-    edgeOK := make([]WorkflowEdge, 0, len(req.Edges))
-    for _, e := range req.Edges {
-        if e.From == "" || e.To == "" {
-            continue
-        }
-        if !ids[e.From] || !ids[e.To] {
-            continue
+    for i := range req.Nodes {
+        if req.Nodes[i].Status == "" {
+            req.Nodes[i].Status = "pending"
         }
-        edgeOK = append(edgeOK, e)
     }
 
-    // 更新工作流
-    wf := WorkflowResponse{Nodes: req.Nodes, Edges: edgeOK}
-    createdWorkflows[id] = wf
-
-    // 更新摘要
+    // 更新工作流与摘要
+    wf := WorkflowResponse{Nodes: req.Nodes, Edges: req.Edges}
     name := strings.TrimSpace(req.Name)
     if name == "" {
         name = id
@@ -824,29 +802,45 @@ func updateWorkflow(w http.ResponseWriter, r *http.Request, id string) {
         status = req.Nodes[0].Status
     }
 
-    createdSummaries[id] = WorkflowSummary{
-        ID:     id,
-        Name:   name,
-        Desc:   desc,
-        Status: status,
+    err = store.UpdateWorkflow(id, func(s *WorkflowSummary, cur *WorkflowResponse) error {
+        if !canModify(p, s.OwnerID) {
+            return errForbidden
+        }
+        *cur = wf
+        *s = WorkflowSummary{
+            ID:          id,
+            Name:        name,
+            Desc:        desc,
+            Status:      status,
+            OwnerID:     s.OwnerID,
+            WorkspaceID: s.WorkspaceID,
+        }
+        return nil
+    })
+    if err == errForbidden {
+        writeForbidden(w)
+        return
+    }
+    if err != nil {
+        writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workflow not found or not editable"})
+        return
     }
 
     writeJSON(w, http.StatusOK, wf)
 }
 
 func deleteWorkflow(w http.ResponseWriter, r *http.Request, id string) {
-    createdMu.Lock()
-    defer createdMu.Unlock()
-
-    // 检查工作流是否存在（仅支持删除用户创建的工作流）
-    if _, ok := createdWorkflows[id]; !ok {
+    // 删除工作流和摘要（仅支持删除用户创建的工作流）
+    p, _ := currentPrincipal(r)
+    if s, ok := store.GetWorkflowSummary(id); ok && !canModify(p, s.OwnerID) {
+        writeForbidden(w)
+        return
+    }
+    if !store.DeleteWorkflow(id) {
         writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workflow not found or not deletable"})
         return
     }
-
-    // 删除工作流和摘要
-    delete(createdWorkflows, id)
-    delete(createdSummaries, id)
+    dropWorkflowRuns(id)
 
     writeJSON(w, http.StatusNoContent, nil)
 }
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 工作流运行记录
+type WorkflowRun struct {
+	ID         string         `json:"id"`
+	WorkflowID string         `json:"workflowId"`
+	Status     string         `json:"status"` // pending | running | success | failed
+	Workers    int            `json:"workers"`
+	Order      []string       `json:"order"` // 拓扑序
+	Nodes      []WorkflowNode `json:"nodes"`
+	Edges      []WorkflowEdge `json:"edges"`
+	Error      string         `json:"error,omitempty"`
+	CreatedAt  int64          `json:"createdAt"`
+	StartedAt  int64          `json:"startedAt,omitempty"`
+	FinishedAt int64          `json:"finishedAt,omitempty"`
+
+	seq int // 创建顺序，用于列表排序
+}
+
+type CreateRunRequest struct {
+	Workers int `json:"workers"` // 可选，覆盖默认并发度
+}
+
+// 单个节点的执行函数；返回 error 表示节点失败
+type nodeExecutor func(ctx context.Context, run string, node WorkflowNode) error
+
+// 默认执行器：模拟耗时任务
+var executeNode nodeExecutor = func(ctx context.Context, run string, node WorkflowNode) error {
+	d := time.Duration(200+rand.Intn(800)) * time.Millisecond
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var errWorkflowCycle = errors.New("workflow contains a cycle")
+
+// 每个工作流保留的运行记录数，超出时丢弃最早结束的
+const maxRunsPerWorkflow = 20
+
+// ---- In-memory store for workflow runs ----
+var (
+	runsMu  sync.RWMutex
+	runs    = map[string]*WorkflowRun{}
+	runsSeq = 0
+)
+
+// 按边对节点做拓扑排序（Kahn），同层按节点定义顺序稳定输出
+func topoOrder(nodes []WorkflowNode, edges []WorkflowEdge) ([]string, error) {
+	indeg := make(map[string]int, len(nodes))
+	pos := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		indeg[n.ID] = 0
+		pos[n.ID] = i
+	}
+	children := map[string][]string{}
+	for _, e := range edges {
+		if _, ok := indeg[e.From]; !ok {
+			continue
+		}
+		if _, ok := indeg[e.To]; !ok {
+			continue
+		}
+		children[e.From] = append(children[e.From], e.To)
+		indeg[e.To]++
+	}
+
+	var ready []string
+	for _, n := range nodes {
+		if indeg[n.ID] == 0 {
+			ready = append(ready, n.ID)
+		}
+	}
+	order := make([]string, 0, len(nodes))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		var next []string
+		for _, c := range children[id] {
+			indeg[c]--
+			if indeg[c] == 0 {
+				next = append(next, c)
+			}
+		}
+		sort.Slice(next, func(i, j int) bool { return pos[next[i]] < pos[next[j]] })
+		ready = append(ready, next...)
+	}
+	if len(order) != len(nodes) {
+		return nil, errWorkflowCycle
+	}
+	return order, nil
+}
+
+// 查找可运行的工作流定义：优先用户创建，回退到 mock
+func lookupWorkflow(id string) WorkflowResponse {
+	if wf, ok := store.GetWorkflow(id); ok {
+		return wf
+	}
+	return mockWorkflowByID(id)
+}
+
+// 启动一次运行；节点在后台按依赖关系并发执行
+func startRun(workflowID string, workers int) (WorkflowRun, error) {
+	wf := lookupWorkflow(workflowID)
+	order, err := topoOrder(wf.Nodes, wf.Edges)
+	if err != nil {
+		return WorkflowRun{}, err
+	}
+	if workers <= 0 {
+		workers = cfg.RunWorkers
+	}
+	// 并发度不超过节点数，多余的 worker 不会被用到
+	if workers > len(order) {
+		workers = len(order)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	nodes := make([]WorkflowNode, len(wf.Nodes))
+	copy(nodes, wf.Nodes)
+	for i := range nodes {
+		nodes[i].Status = "pending"
+	}
+	edges := make([]WorkflowEdge, len(wf.Edges))
+	copy(edges, wf.Edges)
+
+	runsMu.Lock()
+	runsSeq++
+	run := &WorkflowRun{
+		ID:         fmt.Sprintf("run-%d", runsSeq),
+		seq:        runsSeq,
+		WorkflowID: workflowID,
+		Status:     "pending",
+		Workers:    workers,
+		Order:      order,
+		Nodes:      nodes,
+		Edges:      edges,
+		CreatedAt:  time.Now().Unix(),
+	}
+	runs[run.ID] = run
+	pruneRuns(workflowID)
+	snapshot := run.snapshot()
+	runsMu.Unlock()
+
+	go executeRun(run)
+	return snapshot, nil
+}
+
+// 超出 maxRunsPerWorkflow 时按创建顺序丢弃已结束的运行；调用方需持有 runsMu
+func pruneRuns(workflowID string) {
+	var finished []*WorkflowRun
+	total := 0
+	for _, r := range runs {
+		if r.WorkflowID != workflowID {
+			continue
+		}
+		total++
+		if r.Status == "success" || r.Status == "failed" {
+			finished = append(finished, r)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].seq < finished[j].seq })
+	for _, r := range finished {
+		if total <= maxRunsPerWorkflow {
+			break
+		}
+		delete(runs, r.ID)
+		total--
+	}
+}
+
+// 删除工作流时一并丢弃其运行记录；仍在执行的运行结束后不再可查
+func dropWorkflowRuns(workflowID string) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+	for id, r := range runs {
+		if r.WorkflowID == workflowID {
+			delete(runs, id)
+		}
+	}
+}
+
+// 调用方需持有 runsMu
+func (r *WorkflowRun) snapshot() WorkflowRun {
+	s := *r
+	s.Nodes = make([]WorkflowNode, len(r.Nodes))
+	copy(s.Nodes, r.Nodes)
+	return s
+}
+
+func (r *WorkflowRun) setNodeStatus(nodeID, status string) {
+	runsMu.Lock()
+	for i := range r.Nodes {
+		if r.Nodes[i].ID == nodeID {
+			r.Nodes[i].Status = status
+			break
+		}
+	}
+	runsMu.Unlock()
+	syncCreatedNodeStatus(r.WorkflowID, nodeID, status)
+}
+
+func (r *WorkflowRun) setStatus(status string) {
+	runsMu.Lock()
+	r.Status = status
+	now := time.Now().Unix()
+	switch status {
+	case "running":
+		r.StartedAt = now
+	case "success", "failed":
+		r.FinishedAt = now
+	}
+	runsMu.Unlock()
+	syncCreatedSummaryStatus(r.WorkflowID, r.ID, status)
+}
+
+// 仅记录首个失败原因
+func (r *WorkflowRun) setError(msg string) {
+	runsMu.Lock()
+	if r.Error == "" {
+		r.Error = msg
+	}
+	runsMu.Unlock()
+}
+
+type nodeResult struct {
+	id  string
+	err error
+}
+
+// 调度器：维护剩余入度，就绪节点投递给 worker 池；
+// 上游失败或被跳过的节点标记为 skipped，不再执行
+func executeRun(run *WorkflowRun) {
+	parents := map[string][]string{}
+	children := map[string][]string{}
+	indeg := map[string]int{}
+	for _, n := range run.Nodes {
+		indeg[n.ID] = 0
+	}
+	for _, e := range run.Edges {
+		if _, ok := indeg[e.From]; !ok {
+			continue
+		}
+		if _, ok := indeg[e.To]; !ok {
+			continue
+		}
+		children[e.From] = append(children[e.From], e.To)
+		parents[e.To] = append(parents[e.To], e.From)
+		indeg[e.To]++
+	}
+	byID := map[string]WorkflowNode{}
+	for _, n := range run.Nodes {
+		byID[n.ID] = n
+	}
+
+	ctx := context.Background()
+	jobs := make(chan string, len(run.Nodes))
+	results := make(chan nodeResult, len(run.Nodes))
+	var wg sync.WaitGroup
+	for i := 0; i < run.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				run.setNodeStatus(id, "running")
+				results <- nodeResult{id: id, err: executeNode(ctx, run.ID, byID[id])}
+			}
+		}()
+	}
+
+	syncCreatedNodeStatus(run.WorkflowID, "", "pending")
+	run.setStatus("running")
+	final := map[string]string{}
+	inflight := 0
+
+	// 节点完成（或被跳过）后解锁子节点
+	var release func(id string)
+	release = func(id string) {
+		for _, c := range children[id] {
+			indeg[c]--
+			if indeg[c] > 0 {
+				continue
+			}
+			blocked := false
+			for _, p := range parents[c] {
+				if final[p] != "success" {
+					blocked = true
+					break
+				}
+			}
+			if blocked {
+				final[c] = "skipped"
+				run.setNodeStatus(c, "skipped")
+				release(c)
+				continue
+			}
+			jobs <- c
+			inflight++
+		}
+	}
+
+	for _, id := range run.Order {
+		if indeg[id] == 0 {
+			jobs <- id
+			inflight++
+		}
+	}
+	for inflight > 0 {
+		res := <-results
+		inflight--
+		status := "success"
+		if res.err != nil {
+			status = "failed"
+			run.setError(fmt.Sprintf("node %s failed: %v", res.id, res.err))
+		}
+		final[res.id] = status
+		run.setNodeStatus(res.id, status)
+		release(res.id)
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := false
+	for _, st := range final {
+		if st != "success" {
+			failed = true
+			break
+		}
+	}
+	if failed {
+		run.setStatus("failed")
+	} else {
+		run.setStatus("success")
+	}
+}
+
+// 用户创建的工作流同步节点状态，使 GET /api/v1/workflows/{id} 可见运行进度；
+// nodeID 为空时重置全部节点
+func syncCreatedNodeStatus(workflowID, nodeID, status string) {
+	_ = store.UpdateWorkflow(workflowID, func(_ *WorkflowSummary, wf *WorkflowResponse) error {
+		for i := range wf.Nodes {
+			if nodeID == "" || wf.Nodes[i].ID == nodeID {
+				wf.Nodes[i].Status = status
+			}
+		}
+		return nil
+	})
+}
+
+// 运行中记录 ActiveRun，结束后清除；运行只保存在内存，重启后由 migrateInterruptedRuns 重置
+func syncCreatedSummaryStatus(workflowID, runID, status string) {
+	_ = store.UpdateWorkflow(workflowID, func(s *WorkflowSummary, _ *WorkflowResponse) error {
+		s.Status = status
+		s.ActiveRun = ""
+		if status == "running" {
+			s.ActiveRun = runID
+		}
+		return nil
+	})
+}
+
+// POST /api/v1/workflows/{id}/runs (start), GET /api/v1/workflows/{id}/runs (list)
+// GET /api/v1/workflows/{id}/runs/{runId}
+func workflowRunsHandler(w http.ResponseWriter, r *http.Request, workflowID, runID string) {
+	if runID != "" {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		getWorkflowRun(w, r, workflowID, runID)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		listWorkflowRuns(w, r, workflowID)
+	case http.MethodPost:
+		createWorkflowRun(w, r, workflowID)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+func createWorkflowRun(w http.ResponseWriter, r *http.Request, workflowID string) {
+	// 运行会改写工作流的节点与摘要状态，与修改工作流同样需要所有者或管理员权限；
+	// mock 工作流没有所有者，viewer 以外均可运行
+	p, _ := currentPrincipal(r)
+	allowed := canCreate(p)
+	if s, ok := store.GetWorkflowSummary(workflowID); ok {
+		allowed = canModify(p, s.OwnerID)
+	}
+	if !allowed {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	var req CreateRunRequest
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+			return
+		}
+	}
+	if req.Workers < 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "workers must be positive"})
+		return
+	}
+	if req.Workers > cfg.RunMaxWorkers {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("workers must not exceed %d", cfg.RunMaxWorkers)})
+		return
+	}
+
+	run, err := startRun(workflowID, req.Workers)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func listWorkflowRuns(w http.ResponseWriter, r *http.Request, workflowID string) {
+	runsMu.RLock()
+	list := make([]WorkflowRun, 0)
+	for _, run := range runs {
+		if run.WorkflowID == workflowID {
+			list = append(list, run.snapshot())
+		}
+	}
+	runsMu.RUnlock()
+
+	// 最新的运行在前
+	sort.Slice(list, func(i, j int) bool { return list[i].seq > list[j].seq })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"runs": list, "total": len(list)})
+}
+
+func getWorkflowRun(w http.ResponseWriter, r *http.Request, workflowID, runID string) {
+	runsMu.RLock()
+	run, ok := runs[runID]
+	var snapshot WorkflowRun
+	if ok {
+		snapshot = run.snapshot()
+	}
+	runsMu.RUnlock()
+
+	if !ok || snapshot.WorkflowID != workflowID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Run not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
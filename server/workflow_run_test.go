@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 替换节点执行器，测试结束后恢复
+func stubExecuteNode(t *testing.T, fn nodeExecutor) {
+	orig := executeNode
+	executeNode = fn
+	t.Cleanup(func() { executeNode = orig })
+}
+
+func testRun(t *testing.T, ids string, edges []WorkflowEdge, workers int) *WorkflowRun {
+	var nodes []WorkflowNode
+	for _, id := range strings.Split(ids, "") {
+		nodes = append(nodes, WorkflowNode{ID: id, Status: "pending"})
+	}
+	order, err := topoOrder(nodes, edges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WorkflowRun{ID: "run-test", WorkflowID: "wf-test", Workers: workers, Order: order, Nodes: nodes, Edges: edges}
+}
+
+func nodeStatuses(run *WorkflowRun) map[string]string {
+	out := map[string]string{}
+	for _, n := range run.Nodes {
+		out[n.ID] = n.Status
+	}
+	return out
+}
+
+func TestTopoOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes string
+		edges []WorkflowEdge
+		want  []string
+		err   bool
+	}{
+		{name: "no edges keeps definition order", nodes: "CAB", want: []string{"C", "A", "B"}},
+		{name: "chain", nodes: "CBA", edges: []WorkflowEdge{{From: "A", To: "B"}, {From: "B", To: "C"}}, want: []string{"A", "B", "C"}},
+		{
+			name:  "diamond",
+			nodes: "ABCD",
+			edges: []WorkflowEdge{{From: "A", To: "C"}, {From: "A", To: "B"}, {From: "B", To: "D"}, {From: "C", To: "D"}},
+			want:  []string{"A", "B", "C", "D"},
+		},
+		{name: "edge to unknown node ignored", nodes: "AB", edges: []WorkflowEdge{{From: "A", To: "Z"}}, want: []string{"A", "B"}},
+		{name: "cycle", nodes: "ABC", edges: []WorkflowEdge{{From: "A", To: "B"}, {From: "B", To: "C"}, {From: "C", To: "B"}}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var nodes []WorkflowNode
+			for _, id := range strings.Split(tt.nodes, "") {
+				nodes = append(nodes, WorkflowNode{ID: id})
+			}
+			got, err := topoOrder(nodes, tt.edges)
+			if tt.err {
+				if err != errWorkflowCycle {
+					t.Fatalf("err = %v, want errWorkflowCycle", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 菱形 A->B/C->D->E，另有独立节点 F；C 失败时 D、E 跳过，其余照常执行
+func TestExecuteRunSkipsDownstreamOfFailure(t *testing.T) {
+	var mu sync.Mutex
+	var executed []string
+	stubExecuteNode(t, func(ctx context.Context, run string, node WorkflowNode) error {
+		mu.Lock()
+		executed = append(executed, node.ID)
+		mu.Unlock()
+		if node.ID == "C" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	run := testRun(t, "ABCDEF", []WorkflowEdge{
+		{From: "A", To: "B"}, {From: "A", To: "C"},
+		{From: "B", To: "D"}, {From: "C", To: "D"},
+		{From: "D", To: "E"},
+	}, 2)
+	executeRun(run)
+
+	want := map[string]string{"A": "success", "B": "success", "C": "failed", "D": "skipped", "E": "skipped", "F": "success"}
+	if got := nodeStatuses(run); !reflect.DeepEqual(got, want) {
+		t.Errorf("node statuses = %v, want %v", got, want)
+	}
+	if run.Status != "failed" {
+		t.Errorf("run status = %q, want failed", run.Status)
+	}
+	if !strings.Contains(run.Error, "node C failed") {
+		t.Errorf("run error = %q, want the failure of C", run.Error)
+	}
+	for _, id := range executed {
+		if id == "D" || id == "E" {
+			t.Errorf("skipped node %s was executed", id)
+		}
+	}
+	if run.StartedAt == 0 || run.FinishedAt == 0 {
+		t.Errorf("run timestamps not set: started %d, finished %d", run.StartedAt, run.FinishedAt)
+	}
+}
+
+func TestExecuteRunRespectsWorkerCap(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	stubExecuteNode(t, func(ctx context.Context, run string, node WorkflowNode) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+
+	run := testRun(t, "ABCDEF", nil, 2)
+	executeRun(run)
+
+	if peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+	if run.Status != "success" {
+		t.Errorf("run status = %q, want success", run.Status)
+	}
+	for id, st := range nodeStatuses(run) {
+		if st != "success" {
+			t.Errorf("node %s = %q, want success", id, st)
+		}
+	}
+}
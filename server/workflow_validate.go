@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 图结构校验发现的单个问题
+type graphProblem struct {
+	Code    string        `json:"code"` // no_nodes | empty_node_id | duplicate_node | unknown_node | self_loop | duplicate_edge | cycle
+	Message string        `json:"message"`
+	Node    string        `json:"node,omitempty"`
+	Edge    *WorkflowEdge `json:"edge,omitempty"`
+	Path    []string      `json:"path,omitempty"` // 环路径（首尾相同）
+}
+
+// 校验工作流 DAG，返回全部问题（而不是遇到第一个就停止）
+func validateWorkflowGraph(nodes []WorkflowNode, edges []WorkflowEdge) []graphProblem {
+	var problems []graphProblem
+	if len(nodes) == 0 {
+		return append(problems, graphProblem{Code: "no_nodes", Message: "At least one node is required"})
+	}
+
+	// 节点 ID
+	ids := map[string]bool{}
+	order := make([]string, 0, len(nodes))
+	for i, n := range nodes {
+		if strings.TrimSpace(n.ID) == "" {
+			problems = append(problems, graphProblem{Code: "empty_node_id", Message: fmt.Sprintf("Node #%d has no ID", i+1)})
+			continue
+		}
+		if ids[n.ID] {
+			problems = append(problems, graphProblem{Code: "duplicate_node", Message: "Duplicate node ID: " + n.ID, Node: n.ID})
+			continue
+		}
+		ids[n.ID] = true
+		order = append(order, n.ID)
+	}
+
+	// 边：端点存在、无自环、无重复
+	adj := map[string][]string{}
+	seen := map[[2]string]bool{}
+	for i := range edges {
+		e := edges[i]
+		bad := false
+		for _, end := range []string{e.From, e.To} {
+			if !ids[end] {
+				problems = append(problems, graphProblem{Code: "unknown_node", Message: fmt.Sprintf("Edge %s -> %s references unknown node %q", e.From, e.To, end), Node: end, Edge: &e})
+				bad = true
+			}
+		}
+		if bad {
+			continue
+		}
+		if e.From == e.To {
+			problems = append(problems, graphProblem{Code: "self_loop", Message: "Self-loop on node " + e.From, Node: e.From, Edge: &e})
+			continue
+		}
+		key := [2]string{e.From, e.To}
+		if seen[key] {
+			problems = append(problems, graphProblem{Code: "duplicate_edge", Message: fmt.Sprintf("Duplicate edge %s -> %s", e.From, e.To), Edge: &e})
+			continue
+		}
+		seen[key] = true
+		adj[e.From] = append(adj[e.From], e.To)
+	}
+
+	for _, cycle := range findCycles(order, adj) {
+		problems = append(problems, graphProblem{Code: "cycle", Message: "Cycle detected: " + strings.Join(cycle, " -> "), Path: cycle})
+	}
+
+	return problems
+}
+
+// DFS 三色标记，每条回边报告一个环（路径首尾为同一节点）
+func findCycles(order []string, adj map[string][]string) [][]string {
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := map[string]int{}
+	var stack []string
+	var cycles [][]string
+
+	var visit func(u string)
+	visit = func(u string) {
+		color[u] = grey
+		stack = append(stack, u)
+		for _, v := range adj[u] {
+			switch color[v] {
+			case white:
+				visit(v)
+			case grey:
+				start := len(stack) - 1
+				for stack[start] != v {
+					start--
+				}
+				cycle := append([]string{}, stack[start:]...)
+				cycles = append(cycles, append(cycle, v))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[u] = black
+	}
+	for _, id := range order {
+		if color[id] == white {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
+// 校验失败时写出 422，列出全部问题
+func writeGraphProblems(w http.ResponseWriter, problems []graphProblem) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error":    "Invalid workflow graph",
+		"problems": problems,
+	})
+}
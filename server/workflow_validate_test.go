@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func wfNodes(ids ...string) []WorkflowNode {
+	nodes := make([]WorkflowNode, len(ids))
+	for i, id := range ids {
+		nodes[i] = WorkflowNode{ID: id}
+	}
+	return nodes
+}
+
+func TestValidateWorkflowGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []WorkflowNode
+		edges []WorkflowEdge
+		codes []string
+		path  []string // 首个问题的 Path
+	}{
+		{
+			name:  "valid chain",
+			nodes: wfNodes("a", "b", "c"),
+			edges: []WorkflowEdge{{From: "a", To: "b"}, {From: "b", To: "c"}},
+		},
+		{
+			name:  "independent branches are allowed",
+			nodes: wfNodes("a", "b", "c", "d"),
+			edges: []WorkflowEdge{{From: "a", To: "b"}, {From: "c", To: "d"}},
+		},
+		{
+			name:  "single node without edges",
+			nodes: wfNodes("a"),
+		},
+		{
+			name:  "no nodes",
+			codes: []string{"no_nodes"},
+		},
+		{
+			name:  "self loop",
+			nodes: wfNodes("a", "b"),
+			edges: []WorkflowEdge{{From: "a", To: "b"}, {From: "b", To: "b"}},
+			codes: []string{"self_loop"},
+		},
+		{
+			name:  "duplicate edge",
+			nodes: wfNodes("a", "b"),
+			edges: []WorkflowEdge{{From: "a", To: "b"}, {From: "a", To: "b", Label: "again"}},
+			codes: []string{"duplicate_edge"},
+		},
+		{
+			name:  "two node cycle",
+			nodes: wfNodes("a", "b"),
+			edges: []WorkflowEdge{{From: "a", To: "b"}, {From: "b", To: "a"}},
+			codes: []string{"cycle"},
+			path:  []string{"a", "b", "a"},
+		},
+		{
+			name:  "cycle path starts at the back edge target",
+			nodes: wfNodes("s", "a", "b", "c"),
+			edges: []WorkflowEdge{{From: "s", To: "a"}, {From: "a", To: "b"}, {From: "b", To: "c"}, {From: "c", To: "a"}},
+			codes: []string{"cycle"},
+			path:  []string{"a", "b", "c", "a"},
+		},
+		{
+			name:  "unknown node",
+			nodes: wfNodes("a"),
+			edges: []WorkflowEdge{{From: "a", To: "x"}},
+			codes: []string{"unknown_node"},
+		},
+		{
+			name:  "duplicate and empty node ids",
+			nodes: wfNodes("a", "a", " "),
+			codes: []string{"duplicate_node", "empty_node_id"},
+		},
+		{
+			name:  "all problems are reported",
+			nodes: wfNodes("a", "b"),
+			edges: []WorkflowEdge{{From: "a", To: "a"}, {From: "a", To: "b"}, {From: "a", To: "b"}, {From: "b", To: "a"}},
+			codes: []string{"self_loop", "duplicate_edge", "cycle"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateWorkflowGraph(tt.nodes, tt.edges)
+			var codes []string
+			for _, p := range problems {
+				codes = append(codes, p.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.codes) {
+				t.Fatalf("codes = %v, want %v", codes, tt.codes)
+			}
+			if tt.path != nil && !reflect.DeepEqual(problems[0].Path, tt.path) {
+				t.Errorf("path = %v, want %v", problems[0].Path, tt.path)
+			}
+		})
+	}
+}
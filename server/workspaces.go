@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认工作区 ID；示例数据归属于此
+const defaultWorkspaceID = "ws-default"
+
+type Workspace struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	OwnerID   string `json:"ownerId,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// 工作区成员；Role 为成员在该工作区内的角色（admin | editor | viewer）
+type Membership struct {
+	WorkspaceID string `json:"workspaceId"`
+	UserID      string `json:"userId"`
+	Account     string `json:"account"`
+	Role        string `json:"role"`
+	JoinedAt    int64  `json:"joinedAt"`
+}
+
+type WorkspaceResponse struct {
+	Workspace
+	Role   string `json:"role"`   // 调用方在该工作区的角色
+	Active bool   `json:"active"` // 是否为调用方当前工作区
+}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type JoinWorkspaceRequest struct {
+	Code string `json:"code"`
+}
+
+type invitation struct {
+	Role      string
+	InviterID string
+}
+
+// ---- In-memory store for pending invitations ----
+// 邀请码本身由 codes.go 签发与校验，这里只记录邀请的角色
+var (
+	invitesMu sync.Mutex
+	invites   = map[string]invitation{} // codeKey(invitePurpose(ws), email) -> invitation
+)
+
+func invitePurpose(workspaceID string) string {
+	return "invite:" + workspaceID
+}
+
+// 账号级角色优先：admin 在所有已加入的工作区均为 admin，viewer 始终只读；
+// 其余按成员角色
+func effectiveRole(accountRole, memberRole string) string {
+	switch accountRole {
+	case roleAdmin, roleViewer:
+		return accountRole
+	}
+	return memberRole
+}
+
+// 解析请求的当前工作区：优先 X-Workspace-ID 请求头，其次用户上次切换的工作区，
+// 最后为默认工作区。显式指定但不是成员时返回 errForbidden；
+// 没有可用工作区时返回空 ID（只能访问工作区接口本身）
+func resolveWorkspace(r *http.Request, u User) (string, string, error) {
+	if id := strings.TrimSpace(r.Header.Get("X-Workspace-ID")); id != "" {
+		m, ok := store.GetMember(id, u.ID)
+		if !ok {
+			return "", "", errForbidden
+		}
+		return id, effectiveRole(userRole(u), m.Role), nil
+	}
+	for _, id := range []string{u.ActiveWorkspace, defaultWorkspaceID} {
+		if id == "" {
+			continue
+		}
+		if m, ok := store.GetMember(id, u.ID); ok {
+			return id, effectiveRole(userRole(u), m.Role), nil
+		}
+	}
+	return "", "", nil
+}
+
+// 调用方在指定工作区的角色；非成员返回 false
+func workspaceRole(p Principal, workspaceID string) (string, bool) {
+	m, ok := store.GetMember(workspaceID, p.UserID)
+	if !ok {
+		return "", false
+	}
+	return effectiveRole(p.UserRole, m.Role), true
+}
+
+// 按调用方当前工作区读取设备，不属于该工作区视为不存在
+func workspaceDevice(p Principal, id string) (Device, bool) {
+	d, ok := store.GetDevice(id)
+	if !ok || d.WorkspaceID != p.WorkspaceID {
+		return Device{}, false
+	}
+	return d, true
+}
+
+// 新用户加入默认工作区
+func joinDefaultWorkspace(u User) {
+	store.PutMember(Membership{
+		WorkspaceID: defaultWorkspaceID,
+		UserID:      u.ID,
+		Account:     u.Account,
+		Role:        userRole(u),
+		JoinedAt:    u.CreatedAt,
+	})
+}
+
+// GET /api/v1/workspaces (list mine), POST /api/v1/workspaces (create)
+func workspacesCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listWorkspaces(w, r)
+	case http.MethodPost:
+		createWorkspace(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// GET /api/v1/workspaces/{id}, POST /api/v1/workspaces/{id}/activate
+// GET/POST /api/v1/workspaces/{id}/members, PUT/DELETE /api/v1/workspaces/{id}/members/{userId}
+// POST /api/v1/workspaces/{id}/invitations
+func workspaceResourceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/workspaces/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 3 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+	if _, ok := store.GetWorkspace(id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+
+	sub := ""
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+	switch {
+	case sub == "" && len(parts) == 1 && r.Method == http.MethodGet:
+		getWorkspace(w, r, id)
+	case sub == "activate" && len(parts) == 2 && r.Method == http.MethodPost:
+		activateWorkspace(w, r, id)
+	case sub == "invitations" && len(parts) == 2 && r.Method == http.MethodPost:
+		inviteMember(w, r, id)
+	case sub == "members" && len(parts) == 2 && r.Method == http.MethodGet:
+		listMembers(w, r, id)
+	case sub == "members" && len(parts) == 2 && r.Method == http.MethodPost:
+		joinWorkspace(w, r, id)
+	case sub == "members" && len(parts) == 3 && r.Method == http.MethodPut:
+		updateMemberRole(w, r, id, parts[2])
+	case sub == "members" && len(parts) == 3 && r.Method == http.MethodDelete:
+		removeMember(w, r, id, parts[2])
+	case sub == "" || sub == "activate" || sub == "invitations" || sub == "members":
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+func listWorkspaces(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	list := make([]WorkspaceResponse, 0)
+	for _, m := range store.ListUserMemberships(p.UserID) {
+		ws, ok := store.GetWorkspace(m.WorkspaceID)
+		if !ok {
+			continue
+		}
+		list = append(list, WorkspaceResponse{
+			Workspace: ws,
+			Role:      effectiveRole(p.UserRole, m.Role),
+			Active:    ws.ID == p.WorkspaceID,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"workspaces": list, "total": len(list)})
+}
+
+func createWorkspace(w http.ResponseWriter, r *http.Request) {
+	p, _ := currentPrincipal(r)
+	if p.UserRole == roleViewer {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req CreateWorkspaceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Workspace name is required"})
+		return
+	}
+
+	now := time.Now().Unix()
+	ws := Workspace{
+		ID:        fmt.Sprintf("ws-%d", store.NextSeq("workspace")),
+		Name:      name,
+		OwnerID:   p.UserID,
+		CreatedAt: now,
+	}
+	if err := store.CreateWorkspace(ws); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Workspace ID already exists"})
+		return
+	}
+	// 创建者为该工作区管理员
+	store.PutMember(Membership{WorkspaceID: ws.ID, UserID: p.UserID, Account: p.Account, Role: roleAdmin, JoinedAt: now})
+
+	writeJSON(w, http.StatusCreated, WorkspaceResponse{Workspace: ws, Role: roleAdmin})
+}
+
+func getWorkspace(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	role, ok := workspaceRole(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	ws, _ := store.GetWorkspace(id)
+	writeJSON(w, http.StatusOK, WorkspaceResponse{Workspace: ws, Role: role, Active: id == p.WorkspaceID})
+}
+
+// 切换当前工作区（未携带 X-Workspace-ID 时生效）
+func activateWorkspace(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	role, ok := workspaceRole(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	if _, err := store.UpdateUser(p.UserID, func(u *User) error {
+		u.ActiveWorkspace = id
+		return nil
+	}); err != nil {
+		writeUnauthorized(w)
+		return
+	}
+	ws, _ := store.GetWorkspace(id)
+	writeJSON(w, http.StatusOK, WorkspaceResponse{Workspace: ws, Role: role, Active: true})
+}
+
+func listMembers(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := workspaceRole(p, id); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	list := store.ListMembers(id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": list, "total": len(list)})
+}
+
+// 管理员通过邮件邀请成员；复用验证码的签发与发信流程，被邀请人登录后凭邀请码加入
+func inviteMember(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	role, ok := workspaceRole(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	if role != roleAdmin {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req InviteMemberRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	email := normalizeAccount(req.Email)
+	if !strings.Contains(email, "@") {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "A valid email is required"})
+		return
+	}
+	inviteRole := strings.ToLower(strings.TrimSpace(req.Role))
+	if inviteRole == "" {
+		inviteRole = roleEditor
+	}
+	if !validRole(inviteRole) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Role must be admin, editor or viewer"})
+		return
+	}
+	if u, ok := store.GetUserByAccount(email); ok {
+		if _, member := store.GetMember(id, u.ID); member {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "User is already a member"})
+			return
+		}
+	}
+
+	purpose := invitePurpose(id)
+	code, codeID, wait, err := issueCode(purpose, email)
+	if err == errCodeCooldown {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+0.5)))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "Invitation sent too frequently, try again later"})
+		return
+	}
+	ws, _ := store.GetWorkspace(id)
+	data := map[string]interface{}{
+		"Code":       code,
+		"HiddenCode": hideCode(code),
+		"TTLMinutes": int(cfg.CodeTTL.Minutes()),
+		"Inviter":    p.Account,
+		"Workspace":  ws.Name,
+		"Role":       inviteRole,
+	}
+	if err := sendTemplateMail(email, "workspace_invite", data); err != nil {
+		revokeCode(purpose, email)
+		log.Printf("workspace: send invitation to %s failed: %v", email, err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "Failed to send invitation"})
+		return
+	}
+
+	invitesMu.Lock()
+	invites[codeKey(purpose, email)] = invitation{Role: inviteRole, InviterID: p.UserID}
+	invitesMu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"message": fmt.Sprintf("Invitation sent to %s", email),
+		"codeId":  codeID,
+		"role":    inviteRole,
+	})
+}
+
+// 被邀请人（账号须与受邀邮箱一致）凭邀请码加入
+func joinWorkspace(w http.ResponseWriter, r *http.Request, id string) {
+	p, _ := currentPrincipal(r)
+	if _, ok := store.GetMember(id, p.UserID); ok {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Already a member"})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req JoinWorkspaceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	purpose := invitePurpose(id)
+	if err := consumeCode(purpose, p.Account, strings.TrimSpace(req.Code)); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": codeErrorMessage(err)})
+		return
+	}
+	key := codeKey(purpose, p.Account)
+	invitesMu.Lock()
+	inv, ok := invites[key]
+	delete(invites, key)
+	invitesMu.Unlock()
+	if !ok {
+		inv.Role = roleViewer
+	}
+
+	m := Membership{WorkspaceID: id, UserID: p.UserID, Account: p.Account, Role: inv.Role, JoinedAt: time.Now().Unix()}
+	store.PutMember(m)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func updateMemberRole(w http.ResponseWriter, r *http.Request, id, userID string) {
+	p, _ := currentPrincipal(r)
+	role, ok := workspaceRole(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	if role != roleAdmin {
+		writeForbidden(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	var req UpdateRoleRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	newRole := strings.ToLower(strings.TrimSpace(req.Role))
+	if !validRole(newRole) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Role must be admin, editor or viewer"})
+		return
+	}
+	if userID == p.UserID {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Cannot change your own role"})
+		return
+	}
+
+	m, ok := store.GetMember(id, userID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Member not found"})
+		return
+	}
+	m.Role = newRole
+	store.PutMember(m)
+	writeJSON(w, http.StatusOK, m)
+}
+
+// 管理员移除成员，或成员自行退出；不允许移除最后一个管理员
+func removeMember(w http.ResponseWriter, r *http.Request, id, userID string) {
+	p, _ := currentPrincipal(r)
+	role, ok := workspaceRole(p, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Workspace not found"})
+		return
+	}
+	if userID != p.UserID && role != roleAdmin {
+		writeForbidden(w)
+		return
+	}
+
+	m, ok := store.GetMember(id, userID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Member not found"})
+		return
+	}
+	if m.Role == roleAdmin {
+		admins := 0
+		for _, other := range store.ListMembers(id) {
+			if other.Role == roleAdmin {
+				admins++
+			}
+		}
+		if admins <= 1 {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Cannot remove the last admin"})
+			return
+		}
+	}
+
+	store.DeleteMember(id, userID)
+	writeJSON(w, http.StatusNoContent, nil)
+}
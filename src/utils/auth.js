@@ -0,0 +1,20 @@
+// Session token helpers: persist the token returned by /api/v1/auth/sessions
+// and attach it as `Authorization: Bearer <token>` on API requests.
+
+const TOKEN_KEY = 'collabweb.token'
+
+export function getToken() {
+  try { return localStorage.getItem(TOKEN_KEY) || '' } catch (_) { return '' }
+}
+
+export function setToken(token) {
+  try {
+    if (token) localStorage.setItem(TOKEN_KEY, token)
+    else localStorage.removeItem(TOKEN_KEY)
+  } catch (_) {}
+}
+
+export function authHeaders(headers = {}) {
+  const token = getToken()
+  return token ? { ...headers, Authorization: `Bearer ${token}` } : headers
+}